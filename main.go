@@ -4,13 +4,15 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"flag"
 	"fmt"
 	"html/template"
 	"image"
 	"log"
 	"net/http"
-	"time"
+	"strconv"
+	"sync"
 
 	"github.com/x3art/x3t/xt"
 
@@ -21,12 +23,16 @@ import (
 
 type state struct {
 	x    *xt.X
+	cmp  *xt.X // What we compare with on /diff, if anything.
 	tmpl *template.Template
+
+	langMu sync.Mutex
+	langs  map[int]*state // The same in other languages, for ?lang=
 }
 
 var rootTemplates = map[string]string{
-	"/map":   "map",
-	"/about": "about",
+	"/about":    "about",
+	"/problems": "problems",
 }
 
 // rpn calculator for templates
@@ -102,34 +108,36 @@ func calcf(vals ...interface{}) (float64, error) {
 }
 
 var listen = flag.String("listen", "localhost:8080", "listen host:port for the http server")
+var compare = flag.String("compare", "", "another X3 installation to compare with on /diff")
+var lang = flag.Int("lang", xt.English, "language of the text, for example 49 for German or 7 for Russian")
+var strict = flag.Bool("strict", false, "stop reading types files at the first bad record instead of skipping bad records")
 
 func main() {
 	flag.Parse()
 
 	st := state{}
 
-	st.x = xt.NewX(flag.Arg(0))
+	var err error
+	st.x, err = xt.NewXOptions(flag.Arg(0), xt.Options{Lenient: !*strict})
+	if err != nil {
+		log.Fatal(err)
+	}
+	st.x = st.x.WithLanguage(*lang)
 	st.x.PreCache()
-	st.tmpl = template.New("")
-
-	// Register various template funcs that we need.
-	fm := make(template.FuncMap)
-	fm["calc"] = calc
-	fm["calcf"] = calcf
-	st.mapFuncs(fm)
-	st.shipFuncs(fm)
-	st.tmpl.Funcs(fm)
-
-	if tmplDir, err := AssetDir("templates"); err == nil {
-		for _, tn := range tmplDir {
-			template.Must(st.tmpl.New(tn).Parse(string(MustAsset("templates/" + tn))))
+	if *compare != "" {
+		st.cmp, err = xt.NewX(*compare)
+		if err != nil {
+			log.Fatal(err)
 		}
+		st.cmp = st.cmp.WithLanguage(*lang)
+		st.cmp.PreCache()
 	}
+	st.setupTemplates()
 
 	// Why don't these templates live in their own "directory" in assets like static do?
 	for n := range rootTemplates {
 		t := rootTemplates[n]
-		http.HandleFunc(n, func(w http.ResponseWriter, req *http.Request) {
+		st.handle(n, func(st *state, w http.ResponseWriter, req *http.Request) {
 			err := st.tmpl.ExecuteTemplate(w, t, st.x)
 			if err != nil {
 				log.Fatal(err)
@@ -137,9 +145,17 @@ func main() {
 		})
 	}
 
-	http.HandleFunc("/ship/", st.ship)
-	http.HandleFunc("/ships", st.ships)
-	http.HandleFunc("/sector/", st.sector)
+	st.handle("/ship/", (*state).ship)
+	st.handle("/ships", (*state).ships)
+	st.handle("/laser/", (*state).laser)
+	st.handle("/missile/", (*state).missile)
+	st.handle("/map", (*state).galaxyMap)
+	st.handle("/sector/", (*state).sector)
+	st.handle("/route", (*state).route)
+	st.handle("/diff", (*state).diff)
+	st.handle("/text", (*state).text)
+	st.handle("/text/", (*state).text)
+	http.Handle("/files/", st.files())
 
 	if staticDir, err := AssetDir("static"); err == nil {
 		for _, n := range staticDir {
@@ -155,19 +171,117 @@ func main() {
 	}
 
 	http.HandleFunc("/foo.png", func(w http.ResponseWriter, req *http.Request) {
-		img, _, err := image.Decode(st.x.Open("dds/interface_icons_XT_diff.dds"))
+		f, err := st.x.FS().Open("dds/interface_icons_XT_diff.dds")
 		if err != nil {
-			log.Fatal(err)
+			log.Print(err)
+			http.NotFound(w, req)
+			return
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			log.Print(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		img, _, err := image.Decode(f)
+		if err != nil {
+			log.Print(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 		b := bytes.NewBuffer(nil)
 		err = png.Encode(b, img)
 		if err != nil {
-			log.Fatal(err)
+			log.Print(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
-		// xxx - it wold be nice if xf could return a ModTime.
-		http.ServeContent(w, req, "/foo.png", time.Time{}, bytes.NewReader(b.Bytes()))
+		http.ServeContent(w, req, "/foo.png", fi.ModTime(), bytes.NewReader(b.Bytes()))
 	})
 
 	log.Print("now")
 	log.Fatal(http.ListenAndServe(*listen, nil))
 }
+
+func (st *state) setupTemplates() {
+	st.tmpl = template.New("")
+
+	// Register various template funcs that we need.
+	fm := make(template.FuncMap)
+	fm["calc"] = calc
+	fm["calcf"] = calcf
+	fm["parseError"] = func(err error) *xt.ParseError {
+		var pe *xt.ParseError
+		errors.As(err, &pe)
+		return pe
+	}
+	st.mapFuncs(fm)
+	st.shipFuncs(fm)
+	st.tmpl.Funcs(fm)
+
+	if tmplDir, err := AssetDir("templates"); err == nil {
+		for _, tn := range tmplDir {
+			template.Must(st.tmpl.New(tn).Parse(string(MustAsset("templates/" + tn))))
+		}
+	}
+}
+
+// The template funcs use st.x, so each language needs its own
+// templates.
+func (st *state) forLang(req *http.Request) *state {
+	l, err := strconv.Atoi(req.URL.Query().Get("lang"))
+	if err != nil || l == st.x.Language() {
+		return st
+	}
+	st.langMu.Lock()
+	defer st.langMu.Unlock()
+	if ls := st.langs[l]; ls != nil {
+		return ls
+	}
+	found := false
+	for _, al := range st.x.Languages() {
+		found = found || al == l
+	}
+	if !found {
+		return st
+	}
+	ls := &state{x: st.x.WithLanguage(l)}
+	if st.cmp != nil {
+		ls.cmp = st.cmp.WithLanguage(l)
+	}
+	ls.setupTemplates()
+	if st.langs == nil {
+		st.langs = make(map[int]*state)
+	}
+	st.langs[l] = ls
+	return ls
+}
+
+// Register a handler that gets the state for the language of the request.
+func (st *state) handle(pattern string, h func(*state, http.ResponseWriter, *http.Request)) {
+	http.HandleFunc(pattern, func(w http.ResponseWriter, req *http.Request) {
+		h(st.forLang(req), w, req)
+	})
+}
+
+// The merged files of the installation, as they are after unpacking.
+func (st *state) files() http.Handler {
+	return http.StripPrefix("/files/", http.FileServer(http.FS(st.x.FS())))
+}
+
+type diffReq struct {
+	Compare string
+	Diffs   []xt.RecordDiff
+}
+
+func (st *state) diff(w http.ResponseWriter, req *http.Request) {
+	dr := diffReq{Compare: *compare}
+	if st.cmp != nil {
+		dr.Diffs = xt.DiffTypes(st.x, st.cmp)
+	}
+	err := st.tmpl.ExecuteTemplate(w, "diff", dr)
+	if err != nil {
+		log.Print(err)
+	}
+}
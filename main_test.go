@@ -1,6 +1,11 @@
 package main
 
 import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/x3art/x3t/xt"
@@ -8,6 +13,53 @@ import (
 
 func BenchmarkUniverse(b *testing.B) {
 	for i := 0; i < b.N; i++ {
-		_ = xt.GetUniverse("data/x3_universe.xml")
+		// A new X each time, the universe is only parsed once per X.
+		x, err := xt.NewX("data")
+		if err != nil {
+			b.Skip(err)
+		}
+		_ = x.GetUniverse()
+	}
+}
+
+func TestFiles(t *testing.T) {
+	src := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, "addon", "types"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "addon", "types", "TFoo.txt"), []byte("22;1;\nfoo;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	inst := t.TempDir()
+	if err := os.Mkdir(filepath.Join(inst, "addon"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := xt.WriteCatDat(filepath.Join(inst, "addon", "01"), src); err != nil {
+		t.Fatal(err)
+	}
+	x, err := xt.NewX(inst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := &state{x: x}
+
+	for _, tc := range []struct {
+		rng  string
+		code int
+		body string
+	}{
+		{"", http.StatusOK, "22;1;\nfoo;\n"},
+		{"bytes=6-", http.StatusPartialContent, "foo;\n"},
+	} {
+		req := httptest.NewRequest("GET", "/files/addon/types/TFoo.txt", nil)
+		if tc.rng != "" {
+			req.Header.Set("Range", tc.rng)
+		}
+		w := httptest.NewRecorder()
+		st.files().ServeHTTP(w, req)
+		body, _ := io.ReadAll(w.Result().Body)
+		if w.Code != tc.code || string(body) != tc.body {
+			t.Errorf("range %q: got %d %q, want %d %q", tc.rng, w.Code, body, tc.code, tc.body)
+		}
 	}
 }
@@ -1,15 +1,90 @@
 package main
 
 import (
+	"fmt"
 	"html/template"
 	"log"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/x3art/x3t/xt"
 )
 
+// The galaxy map to show, ?galaxy=name.
+func galaxyName(req *http.Request) string {
+	if g := req.FormValue("galaxy"); g != "" {
+		return g
+	}
+	return xt.DefaultGalaxy
+}
+
+type mapReq struct {
+	Galaxy   string
+	Galaxies []string
+	U        xt.Universe
+	Route    *xt.Route // Highlighted on the map if set.
+
+	// Jump distances from a sector, shown as a heatmap and a table of
+	// the sectors within Within jumps.
+	From   string
+	Within int
+	Jumps  map[xt.SectorPos]int
+	Reach  []reachRow
+	Err    string
+}
+
+type reachRow struct {
+	Jumps int
+	S     *xt.Sector
+}
+
+const defaultWithin = 5
+
+// /map?galaxy=name&from=x,y&within=n
+func (st *state) galaxyMap(w http.ResponseWriter, req *http.Request) {
+	g := galaxyName(req)
+	u, err := st.x.GetGalaxy(g)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	mr := mapReq{Galaxy: g, Galaxies: st.x.Galaxies(), U: u, From: req.FormValue("from"), Within: defaultWithin}
+	if n, err := strconv.Atoi(req.FormValue("within")); err == nil && n >= 0 {
+		mr.Within = n
+	}
+	if mr.From != "" {
+		if from, err := parseSectorPos(mr.From); err != nil {
+			mr.Err = err.Error()
+		} else if u.SectorXY(from.X, from.Y) == nil {
+			mr.Err = fmt.Sprintf("no sector %s", mr.From)
+		} else {
+			mr.Jumps = u.GateGraph().JumpDistances(from)
+			for i := range u.Sectors {
+				s := &u.Sectors[i]
+				if j, ok := mr.Jumps[s.Pos()]; ok && j <= mr.Within {
+					mr.Reach = append(mr.Reach, reachRow{j, s})
+				}
+			}
+			sort.SliceStable(mr.Reach, func(i, j int) bool { return mr.Reach[i].Jumps < mr.Reach[j].Jumps })
+		}
+	}
+	err = st.tmpl.ExecuteTemplate(w, "map", mr)
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+// What map-sector and sector get.
+type sectorReq struct {
+	Galaxy  string
+	S       *xt.Sector
+	U       xt.Universe
+	OnRoute bool
+	Jumps   int // From the sector in mapReq.From, -1 if we don't know.
+}
+
 func (st *state) sector(w http.ResponseWriter, req *http.Request) {
 	s := strings.Split(strings.TrimPrefix(req.URL.Path, "/sector/"), "/")
 	if len(s) != 2 {
@@ -26,12 +101,18 @@ func (st *state) sector(w http.ResponseWriter, req *http.Request) {
 		http.NotFound(w, req)
 		return
 	}
-	u := st.x.GetUniverse()
+	g := galaxyName(req)
+	u, err := st.x.GetGalaxy(g)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 	sect := u.SectorXY(x, y)
 	if sect == nil {
 		http.NotFound(w, req)
+		return
 	}
-	err = st.tmpl.ExecuteTemplate(w, "sector", sect)
+	err = st.tmpl.ExecuteTemplate(w, "sector", sectorReq{Galaxy: g, S: sect, U: u, Jumps: -1})
 	if err != nil {
 		log.Print(err)
 	}
@@ -84,21 +165,39 @@ func (st *state) mapFuncs(fm template.FuncMap) {
 		return ret
 	}
 	fm["validGate"] = func(g xt.Gate) bool {
-		if g.S == "4" {
-			return false
-		}
-		switch g.Gid {
-		case 0, 1, 2, 3:
-			return true
-		default:
+		if g.S != "4" && !g.Usable() {
 			log.Print("unknown gatepos ", g)
-			return false
 		}
+		return g.Usable()
 	}
 	fm["asteroidType"] = st.x.AsteroidType
 	fm["sunPercent"] = st.x.SunPercent
 	fm["DockByID"] = st.x.DockByID
-	fm["SectorXY"] = func(x, y int) *xt.Sector {
-		return st.x.GetUniverse().SectorXY(x, y)
+	fm["mapSector"] = func(r mapReq, s *xt.Sector) sectorReq {
+		sr := sectorReq{Galaxy: r.Galaxy, S: s, U: r.U, Jumps: -1}
+		if j, ok := r.Jumps[s.Pos()]; ok {
+			sr.Jumps = j
+		}
+		if r.Route != nil {
+			for _, rs := range r.Route.Sectors() {
+				sr.OnRoute = sr.OnRoute || rs.Pos() == s.Pos()
+			}
+		}
+		return sr
+	}
+	// Close sectors are hot, the color fades out after 10 jumps.
+	fm["heatOpacity"] = func(jumps int) string {
+		if jumps > 10 {
+			return "0.05"
+		}
+		return fmt.Sprintf("%.2f", 0.7-float64(jumps)*0.065)
+	}
+	// The middle of each sector on the route, for a polyline.
+	fm["routePoints"] = func(r *xt.Route) string {
+		p := []string{}
+		for _, s := range r.Sectors() {
+			p = append(p, fmt.Sprintf("%g,%g", float64(s.X)+0.4, float64(s.Y)+0.4))
+		}
+		return strings.Join(p, " ")
 	}
 }
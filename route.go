@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/x3art/x3t/xt"
+)
+
+type avoidOpt struct {
+	R       int
+	Checked bool
+}
+
+type routeReq struct {
+	Map      mapReq
+	From, To string
+	Avoid    []avoidOpt
+	Distance bool
+	Err      string
+}
+
+// "x,y"
+func parseSectorPos(s string) (xt.SectorPos, error) {
+	var p xt.SectorPos
+	if _, err := fmt.Sscanf(s, "%d,%d", &p.X, &p.Y); err != nil {
+		return p, fmt.Errorf("bad sector %q, want x,y", s)
+	}
+	return p, nil
+}
+
+// /route?from=x,y&to=x,y&avoid=6&avoid=8&distance=1
+func (st *state) route(w http.ResponseWriter, req *http.Request) {
+	g := galaxyName(req)
+	u, err := st.x.GetGalaxy(g)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	rr := routeReq{
+		Map:      mapReq{Galaxy: g, Galaxies: st.x.Galaxies(), U: u},
+		From:     req.FormValue("from"),
+		To:       req.FormValue("to"),
+		Distance: req.FormValue("distance") != "",
+	}
+	opt := xt.RouteOptions{Distance: rr.Distance}
+	avoid := make(map[int]bool)
+	for _, a := range req.Form["avoid"] {
+		if r, err := strconv.Atoi(a); err == nil {
+			avoid[r] = true
+			opt.Avoid = append(opt.Avoid, r)
+		}
+	}
+	for _, r := range []int{xt.RaceXenon, xt.RaceKhaak, xt.RacePirates} {
+		rr.Avoid = append(rr.Avoid, avoidOpt{r, avoid[r]})
+	}
+
+	if rr.From != "" && rr.To != "" {
+		from, err := parseSectorPos(rr.From)
+		if err == nil {
+			var to xt.SectorPos
+			to, err = parseSectorPos(rr.To)
+			if err == nil {
+				if rr.Map.Route = u.GateGraph().Route(from, to, opt); rr.Map.Route == nil {
+					err = fmt.Errorf("no route from %s to %s", rr.From, rr.To)
+				}
+			}
+		}
+		if err != nil {
+			rr.Err = err.Error()
+		}
+	}
+	err = st.tmpl.ExecuteTemplate(w, "route", rr)
+	if err != nil {
+		log.Print(err)
+	}
+}
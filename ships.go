@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"html/template"
 	"log"
 	"net/http"
@@ -37,6 +38,43 @@ func (st *state) ship(w http.ResponseWriter, req *http.Request) {
 	http.NotFound(w, req)
 }
 
+type mountReq struct {
+	Name   string
+	Mounts []xt.Mount
+}
+
+// /laser/<ObjectID>, which ships can mount the laser.
+func (st *state) laser(w http.ResponseWriter, req *http.Request) {
+	id := strings.TrimPrefix(req.URL.Path, "/laser/")
+	ls := st.x.GetLasers()
+	for i := range ls {
+		if ls[i].ObjectID == id {
+			err := st.tmpl.ExecuteTemplate(w, "mounts", mountReq{ls[i].Description, st.x.LaserMounts(&ls[i])})
+			if err != nil {
+				log.Print(err)
+			}
+			return
+		}
+	}
+	http.NotFound(w, req)
+}
+
+// /missile/<ObjectID>, which ships can carry the missile.
+func (st *state) missile(w http.ResponseWriter, req *http.Request) {
+	id := strings.TrimPrefix(req.URL.Path, "/missile/")
+	ms := st.x.GetMissiles()
+	for i := range ms {
+		if ms[i].ObjectID == id {
+			err := st.tmpl.ExecuteTemplate(w, "mounts", mountReq{ms[i].Description, st.x.MissileMounts(&ms[i])})
+			if err != nil {
+				log.Print(err)
+			}
+			return
+		}
+	}
+	http.NotFound(w, req)
+}
+
 // filters out a set of ships from all the ships
 type shipFilter interface {
 	Match(*xt.Ship) bool
@@ -188,15 +226,25 @@ var cockpitPos = []string{
 }
 
 func (st *state) shipFuncs(fm template.FuncMap) {
-	fm["maskToLasers"] = func(mask int, wareclass int) (ret []*xt.TLaser) {
-		ls := st.x.GetLasers()
-		for i := range st.x.GetLasers() {
-			if ls[i].WareClass <= wareclass && (st.x.LtMask(ls[i].Index)&uint(mask)) != 0 {
-				ret = append(ret, &ls[i])
+	fm["maskToLasers"] = func(mask xt.LaserMask, wareclass int) (ret []*xt.TLaser) {
+		for _, l := range st.x.MaskLasers(mask) {
+			if l.WareClass <= wareclass {
+				ret = append(ret, l)
 			}
 		}
 		return
 	}
+	fm["maskToMissiles"] = func(mask xt.MissileMask) []*xt.TMissile {
+		return st.x.MaskMissiles(mask)
+	}
+	// {page,id} the text was looked up from, the way X3 writes text references.
+	fm["textID"] = func(rec interface{}, field string) string {
+		p, id, ok := st.x.TextID(rec, field)
+		if !ok {
+			return ""
+		}
+		return fmt.Sprintf("{%d,%d}", p, id)
+	}
 	fm["cockpitPos"] = func(p int) string {
 		return cockpitPos[p]
 	}
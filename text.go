@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/x3art/x3t/xt"
+)
+
+type textReq struct {
+	Q       string
+	Title   string
+	Pages   []xt.TextPage
+	Entries []xt.TextEntry
+}
+
+// How many search results we show.
+const maxTextResults = 500
+
+// /text lists the pages, /text/<page> shows one page and /text?q=
+// searches.
+func (st *state) text(w http.ResponseWriter, req *http.Request) {
+	tr := textReq{Q: req.URL.Query().Get("q")}
+	tmpl := "text"
+	switch p := strings.TrimPrefix(req.URL.Path, "/text"); {
+	case p != "" && p != "/":
+		pid, err := strconv.Atoi(strings.TrimPrefix(p, "/"))
+		if err != nil {
+			http.NotFound(w, req)
+			return
+		}
+		tr.Title = "Page " + strconv.Itoa(pid)
+		for _, tp := range st.x.TextPages() {
+			if tp.ID == pid && tp.Title != "" {
+				tr.Title += " - " + tp.Title
+			}
+		}
+		tr.Entries = st.x.TextPage(pid)
+		tmpl = "textentries"
+	case tr.Q != "":
+		tr.Title = "Search: " + tr.Q
+		tr.Entries = st.x.SearchText(tr.Q, maxTextResults)
+		tmpl = "textentries"
+	default:
+		tr.Pages = st.x.TextPages()
+	}
+	err := st.tmpl.ExecuteTemplate(w, tmpl, tr)
+	if err != nil {
+		log.Print(err)
+	}
+}
@@ -18,10 +18,35 @@ type Scene struct {
 }
 
 func (x *X) Scene(f string) *Scene {
-	r := x.Open(f)
-	if r == nil {
+	sc, err := x.scene(f)
+	if err != nil {
 		return &Scene{}
 	}
+	return sc
+}
+
+// SceneFile loads a scene by the name the types files use for it, like
+// Cockpit.SceneFile or TDock.SceneFile. "ships\argon\m5" is
+// objects/ships/argon/m5.pbd, or .bod if it isn't packed. nil if there
+// is no such scene.
+func (x *X) SceneFile(name string) *Scene {
+	if name == "" {
+		return nil
+	}
+	fn := "objects/" + strings.Replace(name, "\\", "/", -1)
+	for _, ext := range []string{".pbd", ".bod"} {
+		if sc, err := x.scene(fn + ext); err == nil {
+			return sc
+		}
+	}
+	return nil
+}
+
+func (x *X) scene(f string) (*Scene, error) {
+	r, err := x.Open(f)
+	if err != nil {
+		return nil, err
+	}
 	defer r.Close()
 
 	sc := &Scene{}
@@ -56,5 +81,5 @@ func (x *X) Scene(f string) *Scene {
 		}
 		sc.P = append(sc.P, p)
 	}
-	return sc
+	return sc, scan.Err()
 }
@@ -0,0 +1,139 @@
+package xt
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CatWriter produces a cat/dat pair that XFiles can read back.
+//
+// The dat file is just all the files concatenated. The cat file is
+// the name of the dat file on the first line followed by one "path
+// size" line per file, all of it scrambled with the same cookie and
+// offset that parseCD uses to descramble it.
+type CatWriter struct {
+	cat   io.Writer
+	dat   io.Writer
+	index bytes.Buffer
+}
+
+// NewCatWriter writes file data to dat and the index to cat when
+// Close is called. datName ends up on the first line of the cat
+// file, the game doesn't seem to care much about it, but it's
+// normally the base name of the dat file.
+func NewCatWriter(cat, dat io.Writer, datName string) *CatWriter {
+	cw := &CatWriter{cat: cat, dat: dat}
+	fmt.Fprintf(&cw.index, "%s\n", datName)
+	return cw
+}
+
+// Add a file to the archive. name is the path the file will have
+// inside the game, with slashes, for example "types/TShips.pck". The
+// cat gets backslashes like the ones that come with the game.
+func (cw *CatWriter) Add(name string, r io.Reader) error {
+	if name == "" || strings.ContainsAny(name, "\r\n") {
+		return fmt.Errorf("bad file name in cat: %q", name)
+	}
+	n, err := io.Copy(cw.dat, r)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(&cw.index, "%s %d\n", strings.Replace(name, "/", "\\", -1), n)
+	return nil
+}
+
+// Close writes out the scrambled cat file. It doesn't close the
+// underlying writers.
+func (cw *CatWriter) Close() error {
+	s := &stupidScramblerOff{w: cw.cat, cookie: 219, addOff: true}
+	_, err := s.Write(cw.index.Bytes())
+	return err
+}
+
+// WriteCatDat packs all files under dir into basename.cat and
+// basename.dat. Paths inside the archive are relative to dir. If
+// basename is inside dir the cat and dat files being written are
+// left out.
+func WriteCatDat(basename string, dir string) (err error) {
+	fc, err := os.Create(basename + ".cat")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := fc.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	fd, err := os.Create(basename + ".dat")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := fd.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	ci, err := fc.Stat()
+	if err != nil {
+		return err
+	}
+	di, err := fd.Stat()
+	if err != nil {
+		return err
+	}
+
+	cw := NewCatWriter(fc, fd, filepath.Base(basename)+".dat")
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || os.SameFile(info, ci) || os.SameFile(info, di) {
+			return nil
+		}
+		relpath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return cw.Add(filepath.ToSlash(relpath), f)
+	})
+	if err != nil {
+		return err
+	}
+	return cw.Close()
+}
+
+// io.Writer wrapper to scramble data, the inverse of stupidDescramblerOff.
+type stupidScramblerOff struct {
+	w      io.Writer
+	off    int
+	cookie byte
+	addOff bool
+	buf    []byte
+}
+
+func (s *stupidScramblerOff) Write(p []byte) (int, error) {
+	if cap(s.buf) < len(p) {
+		s.buf = make([]byte, len(p))
+	}
+	b := s.buf[:len(p)]
+	for i := range p {
+		if s.addOff {
+			b[i] = p[i] ^ (s.cookie + byte(s.off+i))
+		} else {
+			b[i] = p[i] ^ s.cookie
+		}
+	}
+	n, err := s.w.Write(b)
+	s.off += n
+	return n, err
+}
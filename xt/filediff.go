@@ -0,0 +1,138 @@
+package xt
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Comparing two installations file by file.
+
+// What happened to a file or record between two installations.
+type DiffChange int
+
+const (
+	DiffAdded DiffChange = iota
+	DiffRemoved
+	DiffChanged
+)
+
+func (c DiffChange) String() string {
+	switch c {
+	case DiffAdded:
+		return "added"
+	case DiffRemoved:
+		return "removed"
+	case DiffChanged:
+		return "changed"
+	}
+	return fmt.Sprintf("DiffChange(%d)", int(c))
+}
+
+type FileDiff struct {
+	Path   string
+	Change DiffChange
+	A, B   *Source // Where the file came from on each side, nil if it doesn't exist there.
+	Err    error   // If we couldn't read the file on one of the sides.
+}
+
+func (d FileDiff) String() string {
+	s := fmt.Sprintf("%s %s", d.Path, d.Change)
+	if d.A != nil {
+		s += fmt.Sprintf(" a:%v", *d.A)
+	}
+	if d.B != nil {
+		s += fmt.Sprintf(" b:%v", *d.B)
+	}
+	if d.Err != nil {
+		s += fmt.Sprintf(" (%v)", d.Err)
+	}
+	return s
+}
+
+// Hash of the contents of a file after unpacking.
+func (xf *Xfiles) hash(fn string) ([]byte, error) {
+	f, err := xf.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, fileErrorPath(err, fn)
+	}
+	return h.Sum(nil), nil
+}
+
+func (xf *Xfiles) winner(fn string) *Source {
+	srcs := xf.Which(fn)
+	if len(srcs) == 0 {
+		return nil
+	}
+	s := srcs[len(srcs)-1]
+	return &s
+}
+
+// DiffFiles compares the files in two installations. Files are
+// compared by contents after unpacking, so repacking a file
+// differently doesn't make it different. The paths are the ones Open
+// sees, so an addon file that overrides a base game file is compared
+// with the base game file on the other side. The cat and dat files
+// are left out, their contents are compared instead. The result is
+// sorted by path.
+func DiffFiles(a, b *X) []FileDiff {
+	paths := make(map[string]bool)
+	for _, x := range []*X{a, b} {
+		x.xf.Map(func(dir, fn string) {
+			if ext := path.Ext(fn); ext != ".cat" && ext != ".dat" {
+				paths[joinPath(dir, fn)] = true
+			}
+		})
+	}
+	// Base game files that both sides see through the addon are
+	// compared there, or every base game file would show up twice.
+	viaAddon := func(x *X, p string) bool {
+		return x.xf.lookup(p) == nil || x.xf.resolve(addonPrefix+p) == p
+	}
+	for p := range paths {
+		if !strings.HasPrefix(p, addonPrefix) && viaAddon(a, p) && viaAddon(b, p) {
+			delete(paths, p)
+		}
+	}
+	ret := []FileDiff{}
+	for p := range paths {
+		d := FileDiff{Path: p, A: a.xf.winner(p), B: b.xf.winner(p)}
+		switch {
+		case d.A == nil:
+			d.Change = DiffAdded
+		case d.B == nil:
+			d.Change = DiffRemoved
+		default:
+			ha, err := a.xf.hash(p)
+			if err != nil {
+				d.Err = err
+			}
+			hb, err := b.xf.hash(p)
+			if err != nil && d.Err == nil {
+				d.Err = err
+			}
+			if d.Err == nil && string(ha) == string(hb) {
+				continue
+			}
+			d.Change = DiffChanged
+		}
+		ret = append(ret, d)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Path < ret[j].Path })
+	return ret
+}
+
+func joinPath(d, f string) string {
+	if d == "" {
+		return f
+	}
+	return d + "/" + f
+}
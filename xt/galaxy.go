@@ -0,0 +1,115 @@
+package xt
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Galaxy maps in maps/. x3_universe is the one the normal game
+// starts use, custom games and mods add their own.
+
+const DefaultGalaxy = "x3_universe"
+
+const galaxyDir = "addon/maps"
+
+type galaxyCache struct {
+	once sync.Once
+	u    Universe
+	err  error
+}
+
+// Galaxies returns the names of the galaxy maps in the installation,
+// like "x3_universe" or "x3_custom_2". Those are the xml files in maps/
+// that have a <universe> in them.
+func (x *X) Galaxies() []string {
+	if x.base != nil {
+		return x.base.Galaxies()
+	}
+	x.galaxiesOnce.Do(func() {
+		x.galaxyNames = []string{}
+		for _, fn := range x.xf.files(galaxyDir) {
+			if !strings.HasSuffix(fn, ".xml") {
+				continue
+			}
+			ok, err := x.isGalaxy(galaxyDir + "/" + fn)
+			if err != nil {
+				log.Print(err)
+			}
+			if ok {
+				x.galaxyNames = append(x.galaxyNames, strings.TrimSuffix(fn, ".xml"))
+			}
+		}
+	})
+	return x.galaxyNames
+}
+
+// Just look at the first element, the files are big.
+func (x *X) isGalaxy(fn string) (bool, error) {
+	f, err := x.xf.Open(fn)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	d := xml.NewDecoder(f)
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("%s: %v", fn, err)
+		}
+		if e, ok := t.(xml.StartElement); ok {
+			return e.Name.Local == "universe", nil
+		}
+	}
+}
+
+// GetGalaxy returns a galaxy map by name, see Galaxies. Each one is
+// parsed once and cached.
+func (x *X) GetGalaxy(name string) (Universe, error) {
+	if x.base != nil {
+		// There's no text in it, no need to parse it again.
+		return x.base.GetGalaxy(name)
+	}
+	known := false
+	for _, g := range x.Galaxies() {
+		known = known || g == name
+	}
+	if !known {
+		return Universe{}, fmt.Errorf("no galaxy map %s", name)
+	}
+	x.galaxyMu.Lock()
+	if x.galaxies == nil {
+		x.galaxies = make(map[string]*galaxyCache)
+	}
+	gc := x.galaxies[name]
+	if gc == nil {
+		gc = &galaxyCache{}
+		x.galaxies[name] = gc
+	}
+	x.galaxyMu.Unlock()
+
+	gc.once.Do(func() {
+		fn := galaxyDir + "/" + name + ".xml"
+		f, err := x.xf.Open(fn)
+		if err != nil {
+			gc.err = err
+			return
+		}
+		defer f.Close()
+		d := xml.NewDecoder(f)
+		ok, el := nextEl(d, "universe")
+		if !ok {
+			gc.err = fmt.Errorf("%s: not a universe", fn)
+			return
+		}
+		elem(d, el, reflect.Indirect(reflect.ValueOf(&gc.u)))
+	})
+	return gc.u, gc.err
+}
@@ -0,0 +1,153 @@
+package xt
+
+import (
+	"errors"
+	"math/bits"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// The bit masks in ships and cockpits that tell which lasers and
+// missiles fit. Each bit is a subtype, the Index field of TLaser and
+// TMissile, in the order the subtypes first show up in the file. The
+// game files list the lasers and missiles by subtype, so that's the
+// order the game has, and a mod that adds a subtype gets the next
+// bit. The masks in the files are 32 bits, subtypes that don't fit
+// get no bit and are in Problems.
+
+type LaserMask uint
+type MissileMask uint
+
+const maskBits = 32
+
+var errMaskFull = errors.New("subtype doesn't fit in the 32 bit mask")
+
+type maskTable struct {
+	once  sync.Once
+	bit   map[string]uint
+	names []string
+	errs  []error
+}
+
+func (x *X) maskTable(mt *maskTable, typ string) *maskTable {
+	mt.once.Do(func() {
+		mt.bit = make(map[string]uint)
+		v := reflect.ValueOf(x.getType(typ).v)
+		for i := 0; i < v.Len(); i++ {
+			n := v.Index(i).FieldByName("Index").String()
+			if _, ok := mt.bit[n]; ok || n == "" {
+				continue
+			}
+			if len(mt.names) >= maskBits {
+				pe := &ParseError{File: typeMap[typ].fn, Record: i, Field: "Index", Token: n, Err: errMaskFull}
+				if raw := rawOf(v.Index(i)); raw != nil {
+					pe.Line = raw.line
+				}
+				mt.errs = append(mt.errs, pe)
+				// Only once for each subtype.
+				mt.bit[n] = maskBits
+				continue
+			}
+			mt.bit[n] = uint(len(mt.names))
+			mt.names = append(mt.names, n)
+		}
+	})
+	return mt
+}
+
+func (mt *maskTable) mask(n string) uint {
+	b, ok := mt.bit[n]
+	if !ok || b >= maskBits {
+		return 0
+	}
+	return 1 << b
+}
+
+func (mt *maskTable) list(m uint) []string {
+	ret := []string{}
+	for m != 0 {
+		b := bits.TrailingZeros(m)
+		m &^= 1 << uint(b)
+		if b < len(mt.names) {
+			ret = append(ret, mt.names[b])
+		}
+	}
+	return ret
+}
+
+func (x *X) laserTable() *maskTable {
+	return x.maskTable(&x.laserBits, "Lasers")
+}
+
+func (x *X) missileTable() *maskTable {
+	return x.maskTable(&x.missileBits, "Missiles")
+}
+
+// The bit for a laser subtype ("SG_LASER_IRE"), 0 if we don't know it.
+func (x *X) LaserBit(index string) LaserMask {
+	return LaserMask(x.laserTable().mask(index))
+}
+
+// The bit for a missile subtype ("SG_MISSILE_LIGHT"), 0 if we don't know it.
+func (x *X) MissileBit(index string) MissileMask {
+	return MissileMask(x.missileTable().mask(index))
+}
+
+func (x *X) LtMask(lt string) uint {
+	return uint(x.LaserBit(lt))
+}
+
+// The subtypes in the mask.
+func (x *X) LaserNames(m LaserMask) []string {
+	return x.laserTable().list(uint(m))
+}
+
+func (x *X) MissileNames(m MissileMask) []string {
+	return x.missileTable().list(uint(m))
+}
+
+// All the lasers that fit the mask.
+func (x *X) MaskLasers(m LaserMask) []*TLaser {
+	ret := []*TLaser{}
+	ls := x.GetLasers()
+	for i := range ls {
+		if m.Has(x.LaserBit(ls[i].Index)) {
+			ret = append(ret, &ls[i])
+		}
+	}
+	return ret
+}
+
+// All the missiles that fit the mask.
+func (x *X) MaskMissiles(m MissileMask) []*TMissile {
+	ret := []*TMissile{}
+	ms := x.GetMissiles()
+	for i := range ms {
+		if m.Has(x.MissileBit(ms[i].Index)) {
+			ret = append(ret, &ms[i])
+		}
+	}
+	return ret
+}
+
+// Subtype names without the SG_LASER_ prefix, for humans.
+func (x *X) LaserMaskString(m LaserMask) string {
+	return strings.Replace(strings.Join(x.LaserNames(m), " "), "SG_LASER_", "", -1)
+}
+
+func (x *X) MissileMaskString(m MissileMask) string {
+	return strings.Replace(strings.Join(x.MissileNames(m), " "), "SG_MISSILE_", "", -1)
+}
+
+func (m LaserMask) Has(o LaserMask) bool            { return m&o != 0 }
+func (m LaserMask) Union(o LaserMask) LaserMask     { return m | o }
+func (m LaserMask) Intersect(o LaserMask) LaserMask { return m & o }
+func (m LaserMask) Minus(o LaserMask) LaserMask     { return m &^ o }
+func (m LaserMask) Count() int                      { return bits.OnesCount(uint(m)) }
+
+func (m MissileMask) Has(o MissileMask) bool              { return m&o != 0 }
+func (m MissileMask) Union(o MissileMask) MissileMask     { return m | o }
+func (m MissileMask) Intersect(o MissileMask) MissileMask { return m & o }
+func (m MissileMask) Minus(o MissileMask) MissileMask     { return m &^ o }
+func (m MissileMask) Count() int                          { return bits.OnesCount(uint(m)) }
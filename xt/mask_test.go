@@ -0,0 +1,99 @@
+package xt
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLaserMask(t *testing.T) {
+	lt := reflect.TypeOf(TLaser{})
+	x := testX(t, map[string]string{
+		"addon/t/0001-L044.xml": testText,
+		"addon/types/TLaser.txt": "22;3;\n" +
+			testLine(lt, map[string]string{"Index": "SG_LASER_PAC", "Projectile": "-1", "ObjectID": "A"}) +
+			testLine(lt, map[string]string{"Index": "SG_LASER_MOD", "Projectile": "-1", "ObjectID": "B"}) +
+			testLine(lt, map[string]string{"Index": "SG_LASER_IRE", "Projectile": "-1", "ObjectID": "C"}),
+	})
+	if x.LaserBit("SG_LASER_PAC") != 1 || x.LaserBit("SG_LASER_MOD") != 2 || x.LaserBit("SG_LASER_IRE") != 4 || x.LaserBit("nope") != 0 {
+		t.Errorf("bad bits")
+	}
+	m := LaserMask(3).Union(4).Minus(2)
+	l := x.MaskLasers(m)
+	if len(l) != 2 || l[0].ObjectID != "A" || l[1].ObjectID != "C" {
+		t.Errorf("MaskLasers(%x): %v", m, l)
+	}
+	if s := x.LaserMaskString(m); s != "PAC IRE" {
+		t.Errorf("LaserMaskString: %q", s)
+	}
+	if m.Count() != 2 || !m.Has(4) || m.Intersect(2) != 0 {
+		t.Errorf("set operations")
+	}
+}
+
+func TestLaserMaskFull(t *testing.T) {
+	lt, st := reflect.TypeOf(TLaser{}), reflect.TypeOf(Ship{})
+	lasers := fmt.Sprintf("22;%d;\n", maskBits+1)
+	for i := 0; i <= maskBits; i++ {
+		lasers += testLine(lt, map[string]string{"Index": fmt.Sprintf("SG_LASER_%d", i), "Projectile": "-1"})
+	}
+	x := testX(t, map[string]string{
+		"addon/t/0001-L044.xml":  testText,
+		"addon/types/TLaser.txt": lasers,
+		"addon/types/TShips.txt": "22;1;\n" + testLine(st, map[string]string{"PossibleLasers": "-1"}),
+	})
+	if b := x.LaserBit("SG_LASER_31"); b != 1<<31 {
+		t.Errorf("last bit: %x", b)
+	}
+	var pe *ParseError
+	if p := parseErrors(x); len(p) != 1 || !errors.As(p[0], &pe) || pe.Token != "SG_LASER_32" || pe.Line != 34 || !errors.Is(pe, errMaskFull) {
+		t.Errorf("problems: %v", p)
+	}
+	m := x.GetShips()[0].PossibleLasers
+	if m.Count() != maskBits || len(x.MaskLasers(m)) != maskBits {
+		t.Errorf("-1 mask: %x", m)
+	}
+	var b strings.Builder
+	if err := x.WriteType(&b, "Ships", x.GetShips()); err != nil || !strings.Contains(b.String(), ";-1;") {
+		t.Errorf("written back as %q, %v", b.String(), err)
+	}
+}
+
+func TestMounts(t *testing.T) {
+	lt, st, ct := reflect.TypeOf(TLaser{}), reflect.TypeOf(Ship{}), reflect.TypeOf(Cockpit{})
+	x := testX(t, map[string]string{
+		"addon/t/0001-L044.xml": testText,
+		"addon/types/TLaser.txt": "22;3;\n" +
+			testLine(lt, map[string]string{"Index": "SG_LASER_PAC", "WareClass": "1", "ObjectID": "A"}) +
+			testLine(lt, map[string]string{"Index": "SG_LASER_IRE", "WareClass": "3", "ObjectID": "B"}) +
+			testLine(lt, map[string]string{"Index": "SG_LASER_IRE", "WareClass": "0", "ObjectID": "C"}),
+		"addon/types/TCockpits.txt": "22;1;\n" +
+			testLine(ct, map[string]string{"LaserMask": "2"}),
+		"addon/types/TMissiles.txt": "22;1;\n" +
+			testLine(reflect.TypeOf(TMissile{}), map[string]string{"Index": "SG_MISSILE_LIGHT", "Speed": "500", "WareClass": "1", "ObjectID": "SS_M"}),
+		"addon/types/TShips.txt": "22;1;\n" +
+			testLine(st, map[string]string{
+				"PossibleLasers":              "1",
+				"PossibleMissiles":            "1",
+				"CargoType":                   "2",
+				"NumberOfMissiles":            "4",
+				"TurretDescriptor[0].Cockpit": "0",
+				"TurretDescriptor[0].CPos":    "1",
+			}),
+	})
+	l, s := x.GetLasers(), &x.GetShips()[0]
+	if m := x.LaserMounts(&l[0]); len(m) != 1 || m[0].Ship != s || m[0].Turret != -1 {
+		t.Errorf("main gun mounts: %v", m)
+	}
+	if m := x.LaserMounts(&l[1]); len(m) != 0 {
+		t.Errorf("too big laser mounts: %v", m)
+	}
+	if m := x.LaserMounts(&l[2]); len(m) != 1 || m[0].Turret != 0 || m[0].Cockpit != &x.getType("Cockpits").v.([]Cockpit)[0] {
+		t.Errorf("turret mounts: %v", m)
+	}
+	if m := x.MissileMounts(&x.GetMissiles()[0]); len(m) != 1 || m[0].Guns != 4 {
+		t.Errorf("missile mounts: %v", m)
+	}
+}
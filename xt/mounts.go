@@ -0,0 +1,79 @@
+package xt
+
+import (
+	"sync"
+)
+
+// Which ships can mount which weapons. The other direction of
+// PossibleLasers, LaserMask and PossibleMissiles.
+
+// A place on a ship where a weapon fits.
+type Mount struct {
+	Ship    *Ship
+	Turret  int      // -1 for the main guns (and missiles), otherwise the index in TurretDescriptor.
+	Cockpit *Cockpit // nil for the main guns and missiles.
+	Guns    int      // How many guns there are in that place.
+}
+
+type mountIndex struct {
+	once     sync.Once
+	lasers   map[*TLaser][]Mount
+	missiles map[*TMissile][]Mount
+}
+
+// Ships can only carry wares up to their cargo class.
+func fitsCargo(s *Ship, wareClass int) bool {
+	return wareClass <= s.CargoType
+}
+
+func shipGuns(s *Ship, group int) int {
+	if group < len(s.GunGroup) {
+		return s.GunGroup[group].NumGuns
+	}
+	return 0
+}
+
+func (x *X) mounts() *mountIndex {
+	mi := &x.mountIndex
+	mi.once.Do(func() {
+		mi.lasers = make(map[*TLaser][]Mount)
+		mi.missiles = make(map[*TMissile][]Mount)
+		ships := x.GetShips()
+		for si := range ships {
+			s := &ships[si]
+			for _, l := range x.MaskLasers(s.PossibleLasers) {
+				if fitsCargo(s, l.WareClass) {
+					mi.lasers[l] = append(mi.lasers[l], Mount{Ship: s, Turret: -1, Guns: shipGuns(s, 0)})
+				}
+			}
+			for ti, td := range s.TurretDescriptor {
+				if td.Cockpit == nil {
+					continue
+				}
+				for _, l := range x.MaskLasers(td.Cockpit.LaserMask) {
+					if fitsCargo(s, l.WareClass) {
+						mi.lasers[l] = append(mi.lasers[l], Mount{Ship: s, Turret: ti, Cockpit: td.Cockpit, Guns: shipGuns(s, ti+1)})
+					}
+				}
+			}
+			for _, m := range x.MaskMissiles(s.PossibleMissiles) {
+				if fitsCargo(s, m.WareClass) {
+					mi.missiles[m] = append(mi.missiles[m], Mount{Ship: s, Turret: -1, Guns: s.NumberOfMissiles})
+				}
+			}
+		}
+	})
+	return mi
+}
+
+// LaserMounts returns all the places on all ships where the laser
+// fits. l has to be from GetLasers.
+func (x *X) LaserMounts(l *TLaser) []Mount {
+	return x.mounts().lasers[l]
+}
+
+// MissileMounts returns all the ships that can carry the missile. m
+// has to be from GetMissiles.
+func (x *X) MissileMounts(m *TMissile) []Mount {
+	return x.mounts().missiles[m]
+}
@@ -0,0 +1,27 @@
+package xt
+
+import (
+	"compress/gzip"
+	"io"
+)
+
+// WritePck gzips the data from r and scrambles it into w in a way
+// that pck.Open can read back.
+//
+// For the scramblings with a key byte we write the cookie xor 0xc8,
+// the way the pck format is usually described, see pckFixture in the
+// tests. We never look at the key byte when reading, the cookie comes
+// from the gzip header, so the readers here don't depend on it.
+func WritePck(w io.Writer, r io.Reader, sc PckScrambling, cookie byte) error {
+	if sc == PckKeyXOR || sc == PckKeyXOROff {
+		if _, err := w.Write([]byte{cookie ^ 0xc8}); err != nil {
+			return err
+		}
+	}
+	sw := &stupidScramblerOff{w: w, cookie: cookie, addOff: sc == PckXOROff || sc == PckKeyXOROff}
+	zw := gzip.NewWriter(sw)
+	if _, err := io.Copy(zw, r); err != nil {
+		return err
+	}
+	return zw.Close()
+}
@@ -0,0 +1,266 @@
+package xt
+
+import (
+	"container/heap"
+	"math"
+)
+
+// The gate network and finding the way through it.
+
+// Races in Sector.R that it's often a good idea to stay away from.
+const (
+	RaceXenon   = 6
+	RaceKhaak   = 7
+	RacePirates = 8
+)
+
+// Usable is false for destroyed gates and gates we don't know where
+// they are in the sector.
+func (g *Gate) Usable() bool {
+	if g.S == "4" {
+		return false
+	}
+	return g.Gid >= 0 && g.Gid <= 3
+}
+
+type SectorPos struct {
+	X, Y int
+}
+
+func (s *Sector) Pos() SectorPos {
+	return SectorPos{s.X, s.Y}
+}
+
+// One jump through a gate.
+type Jump struct {
+	From, To *Sector
+	Gate     *Gate // In From.
+	Dest     *Gate // Where we come out in To, nil if To doesn't have that gate.
+}
+
+type GateGraph struct {
+	u     Universe
+	jumps map[*Sector][]Jump
+}
+
+// GateGraph returns the network of usable gates between the sectors.
+func (u Universe) GateGraph() *GateGraph {
+	g := &GateGraph{u: u, jumps: make(map[*Sector][]Jump)}
+	for i := range u.Sectors {
+		s := &u.Sectors[i]
+		for j := range s.Gates {
+			gate := &s.Gates[j]
+			if !gate.Usable() {
+				continue
+			}
+			to := u.SectorXY(gate.Gx, gate.Gy)
+			if to == nil {
+				continue
+			}
+			jmp := Jump{From: s, To: to, Gate: gate}
+			for k := range to.Gates {
+				if to.Gates[k].Gid == gate.Gtid {
+					jmp.Dest = &to.Gates[k]
+					break
+				}
+			}
+			g.jumps[s] = append(g.jumps[s], jmp)
+		}
+	}
+	return g
+}
+
+// Jumps returns the jumps out of a sector.
+func (g *GateGraph) Jumps(s *Sector) []Jump {
+	return g.jumps[s]
+}
+
+type RouteOptions struct {
+	// Weigh the route by how far it is to fly in each sector from the
+	// gate we come in through to the gate we leave through instead of
+	// just the number of jumps. The first sector doesn't count, we
+	// don't know where in it we are. Each jump costs jumpCost on top.
+	Distance bool
+	// Don't go through sectors of these races. The sectors we start
+	// and end in are allowed.
+	Avoid []int
+}
+
+func (o *RouteOptions) avoid(s *Sector) bool {
+	for _, r := range o.Avoid {
+		if s.R == r {
+			return true
+		}
+	}
+	return false
+}
+
+// With distances, what a jump costs in the units of the positions.
+// Otherwise crossing a sector between two gates next to each other is
+// free and the route takes every detour that saves a few meters.
+const jumpCost = 5000
+
+type Route struct {
+	Jumps    []Jump
+	Distance float64 // Flown inside sectors from gate to gate, in the same units as the positions.
+	start    *Sector
+}
+
+// Sectors returns the sectors along the route, including the first
+// and the last. A route to where we already are is just that sector.
+func (r *Route) Sectors() []*Sector {
+	ret := []*Sector{}
+	if len(r.Jumps) == 0 && r.start != nil {
+		return append(ret, r.start)
+	}
+	for i, j := range r.Jumps {
+		if i == 0 {
+			ret = append(ret, j.From)
+		}
+		ret = append(ret, j.To)
+	}
+	return ret
+}
+
+func gateDist(a, b *Gate) float64 {
+	dx, dy, dz := float64(a.X-b.X), float64(a.Y-b.Y), float64(a.Z-b.Z)
+	return math.Sqrt(dx*dx + dy*dy + dz*dz)
+}
+
+// Where we are in the search, in a sector having come in through a
+// gate (nil at the start).
+type routeNode struct {
+	s    *Sector
+	in   *Gate
+	cost float64
+	via  *routeNode
+	jump Jump
+	idx  int
+}
+
+// How far it is to fly from where we came into the sector of n to the
+// gate out. Nothing for the first sector. If the jump in didn't have a
+// gate to come out of we say we're in the middle of the sector, it
+// can't be free.
+func (n *routeNode) legDist(out *Gate) float64 {
+	if n.via == nil {
+		return 0
+	}
+	in := n.in
+	if in == nil {
+		in = &Gate{}
+	}
+	return gateDist(in, out)
+}
+
+type routeQueue []*routeNode
+
+func (q routeQueue) Len() int            { return len(q) }
+func (q routeQueue) Less(i, j int) bool  { return q[i].cost < q[j].cost }
+func (q routeQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i]; q[i].idx = i; q[j].idx = j }
+func (q *routeQueue) Push(x interface{}) { n := x.(*routeNode); n.idx = len(*q); *q = append(*q, n) }
+func (q *routeQueue) Pop() interface{} {
+	old := *q
+	n := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return n
+}
+
+// Route finds the shortest route between two sectors, nil if there
+// isn't one. Sectors are found with SectorXY in the universe the
+// graph was made from.
+func (g *GateGraph) Route(from, to SectorPos, opt RouteOptions) *Route {
+	src, dst := g.u.SectorXY(from.X, from.Y), g.u.SectorXY(to.X, to.Y)
+	if src == nil || dst == nil {
+		return nil
+	}
+	// With distances it matters which gate we came in through, so
+	// that's what the nodes are. Without them all nodes in a sector
+	// are the same and we only visit the first.
+	type key struct {
+		s  *Sector
+		in *Gate
+	}
+	keyOf := func(n *routeNode) key {
+		if opt.Distance {
+			return key{n.s, n.in}
+		}
+		return key{s: n.s}
+	}
+	done := make(map[key]bool)
+	best := make(map[key]*routeNode)
+	q := &routeQueue{}
+	start := &routeNode{s: src}
+	heap.Push(q, start)
+	best[keyOf(start)] = start
+	for q.Len() > 0 {
+		n := heap.Pop(q).(*routeNode)
+		k := keyOf(n)
+		if done[k] {
+			continue
+		}
+		done[k] = true
+		if n.s == dst {
+			return n.route()
+		}
+		for _, j := range g.jumps[n.s] {
+			if j.To != dst && opt.avoid(j.To) {
+				continue
+			}
+			nn := &routeNode{s: j.To, in: j.Dest, via: n, jump: j}
+			if opt.Distance {
+				nn.cost = n.cost + n.legDist(j.Gate) + jumpCost
+			} else {
+				nn.cost = n.cost + 1
+			}
+			nk := keyOf(nn)
+			if done[nk] {
+				continue
+			}
+			if b := best[nk]; b != nil && b.cost <= nn.cost {
+				continue
+			}
+			best[nk] = nn
+			heap.Push(q, nn)
+		}
+	}
+	return nil
+}
+
+func (n *routeNode) route() *Route {
+	r := &Route{}
+	for ; n.via != nil; n = n.via {
+		r.Jumps = append(r.Jumps, n.jump)
+		r.Distance += n.via.legDist(n.jump.Gate)
+	}
+	r.start = n.s
+	for i, j := 0, len(r.Jumps)-1; i < j; i, j = i+1, j-1 {
+		r.Jumps[i], r.Jumps[j] = r.Jumps[j], r.Jumps[i]
+	}
+	return r
+}
+
+// JumpDistances returns how many jumps through gates away each sector
+// that can be reached from from is, 0 for from itself. That's also
+// what the energy cells for a jumpdrive jump are counted by.
+func (g *GateGraph) JumpDistances(from SectorPos) map[SectorPos]int {
+	ret := make(map[SectorPos]int)
+	src := g.u.SectorXY(from.X, from.Y)
+	if src == nil {
+		return ret
+	}
+	ret[from] = 0
+	q := []*Sector{src}
+	for len(q) > 0 {
+		s := q[0]
+		q = q[1:]
+		for _, j := range g.jumps[s] {
+			if _, ok := ret[j.To.Pos()]; ok {
+				continue
+			}
+			ret[j.To.Pos()] = ret[s.Pos()] + 1
+			q = append(q, j.To)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,129 @@
+package xt
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// A sector with gates. Each gate is "gid:gx,gy,gtid" and sits at the
+// edge of the sector in its direction, unless it ends with "@x,z".
+func testSector(x, y, r int, gates ...string) string {
+	s := fmt.Sprintf("\t<o t=\"1\" x=\"%d\" y=\"%d\" r=\"%d\">\n", x, y, r)
+	edge := [][3]int{{0, 0, 10000}, {0, 0, -10000}, {-10000, 0, 0}, {10000, 0, 0}}
+	for _, g := range gates {
+		var gid, gx, gy, gtid int
+		fmt.Sscanf(g, "%d:%d,%d,%d", &gid, &gx, &gy, &gtid)
+		e := edge[gid]
+		if i := strings.Index(g, "@"); i != -1 {
+			fmt.Sscanf(g[i+1:], "%d,%d", &e[0], &e[2])
+		}
+		s += fmt.Sprintf("\t\t<o t=\"18\" gid=\"%d\" x=\"%d\" y=\"%d\" z=\"%d\" s=\"0\" gx=\"%d\" gy=\"%d\" gtid=\"%d\"/>\n", gid, e[0], e[1], e[2], gx, gy, gtid)
+	}
+	return s + "\t</o>\n"
+}
+
+// A galaxy of sectors made with testSector.
+func testSectors(t *testing.T, sectors ...string) Universe {
+	u := "<universe>\n" + strings.Join(sectors, "") + "</universe>\n"
+	x := testX(t, map[string]string{"addon/maps/x3_universe.xml": u})
+	return x.GetUniverse()
+}
+
+// The east gate of 0,1 is far away.
+//
+//	0,0 - 1,0 - 2,0
+//	 |           |
+//	0,1 ------- 2,1 (Xenon)
+//	             |
+//	            2,2
+func testGalaxy(t *testing.T) Universe {
+	return testSectors(t,
+		testSector(0, 0, 1, "3:1,0,2", "1:0,1,0"),
+		testSector(1, 0, 1, "2:0,0,3", "3:2,0,2"),
+		testSector(2, 0, 1, "2:1,0,3", "1:2,1,0"),
+		testSector(0, 1, 1, "0:0,0,1", "3:2,1,2@100000,0"),
+		testSector(2, 1, RaceXenon, "0:2,0,1", "2:0,1,3", "1:2,2,0"),
+		testSector(2, 2, 1, "0:2,1,1"),
+		testSector(5, 5, 1))
+}
+
+func routeString(r *Route) string {
+	if r == nil {
+		return "none"
+	}
+	s := []string{}
+	for _, sect := range r.Sectors() {
+		s = append(s, fmt.Sprintf("%d,%d", sect.X, sect.Y))
+	}
+	return strings.Join(s, " ")
+}
+
+func TestRoute(t *testing.T) {
+	g := testGalaxy(t).GateGraph()
+	for _, tc := range []struct {
+		from, to SectorPos
+		opt      RouteOptions
+		want     string
+	}{
+		{SectorPos{0, 0}, SectorPos{0, 0}, RouteOptions{}, "0,0"},
+		{SectorPos{0, 0}, SectorPos{2, 1}, RouteOptions{}, "0,0 0,1 2,1"},
+		{SectorPos{0, 0}, SectorPos{2, 2}, RouteOptions{}, "0,0 0,1 2,1 2,2"},
+		// The long way around has less flying.
+		{SectorPos{0, 0}, SectorPos{2, 2}, RouteOptions{Distance: true}, "0,0 1,0 2,0 2,1 2,2"},
+		{SectorPos{0, 0}, SectorPos{2, 0}, RouteOptions{Avoid: []int{RaceXenon}}, "0,0 1,0 2,0"},
+		{SectorPos{0, 1}, SectorPos{2, 0}, RouteOptions{Avoid: []int{RaceXenon}}, "0,1 0,0 1,0 2,0"},
+		{SectorPos{0, 0}, SectorPos{2, 2}, RouteOptions{Avoid: []int{RaceXenon}}, "none"},
+		{SectorPos{0, 0}, SectorPos{5, 5}, RouteOptions{}, "none"},
+	} {
+		if got := routeString(g.Route(tc.from, tc.to, tc.opt)); got != tc.want {
+			t.Errorf("%v -> %v %+v: got %q, want %q", tc.from, tc.to, tc.opt, got, tc.want)
+		}
+	}
+	r := g.Route(SectorPos{0, 0}, SectorPos{2, 0}, RouteOptions{})
+	if len(r.Jumps) != 2 || r.Jumps[0].Gate.Gid != 3 || r.Jumps[0].Dest.Gid != 2 || r.Distance != 20000 {
+		t.Errorf("bad route: %+v", r)
+	}
+}
+
+func TestJumpDistances(t *testing.T) {
+	g := testGalaxy(t).GateGraph()
+	want := map[SectorPos]int{{0, 0}: 0, {1, 0}: 1, {0, 1}: 1, {2, 0}: 2, {2, 1}: 2, {2, 2}: 3}
+	if got := g.JumpDistances(SectorPos{0, 0}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := g.JumpDistances(SectorPos{5, 5}); !reflect.DeepEqual(got, map[SectorPos]int{{5, 5}: 0}) {
+		t.Errorf("5,5: got %v", got)
+	}
+}
+
+func TestRouteCost(t *testing.T) {
+	// 1,0 can go straight to 3,0 or save some flying with an extra
+	// jump through 2,0. The jump isn't worth it.
+	g := testSectors(t,
+		testSector(0, 0, 1, "3:1,0,2"),
+		testSector(1, 0, 1, "2:0,0,3", "3:3,0,2@-10000,3000", "1:2,0,0@-10000,1000"),
+		testSector(2, 0, 1, "0:1,0,1", "3:3,0,2@0,9000"),
+		testSector(3, 0, 1, "2:1,0,3")).GateGraph()
+	r := g.Route(SectorPos{0, 0}, SectorPos{3, 0}, RouteOptions{Distance: true})
+	if got := routeString(r); got != "0,0 1,0 3,0" || r.Distance != 3000 {
+		t.Errorf("got %q, %v", got, r.Distance)
+	}
+
+	// The gate from 0,0 to 1,0 comes out of a gate 1,0 doesn't have,
+	// so we don't know how far it is to fly through 1,0. It can't be
+	// nothing.
+	g = testSectors(t,
+		testSector(0, 0, 1, "3:1,0,2", "1:0,1,0"),
+		testSector(1, 0, 1, "1:1,1,0@50000,0"),
+		testSector(0, 1, 1, "0:0,0,1", "3:1,1,2"),
+		testSector(1, 1, 1, "0:1,0,1", "2:0,1,3")).GateGraph()
+	r = g.Route(SectorPos{0, 0}, SectorPos{1, 1}, RouteOptions{Distance: true})
+	if got := routeString(r); got != "0,0 0,1 1,1" {
+		t.Errorf("got %q", got)
+	}
+	if r := g.Route(SectorPos{0, 0}, SectorPos{1, 0}, RouteOptions{Distance: true}); r == nil || r.Jumps[0].Dest != nil {
+		t.Errorf("jump without a gate to come out of: %+v", r)
+	}
+}
@@ -3,6 +3,7 @@ package xt
 import (
 	"encoding/xml"
 	"fmt"
+	"html/template"
 	"log"
 	"regexp"
 	"sort"
@@ -29,88 +30,220 @@ type TextFile struct {
 
 type Text map[int]map[int]string
 
+// Text in the language of x. Strings that aren't translated come
+// from English.
 func (x *X) GetText() Text {
 	x.textOnce.Do(func() {
 		x.text = make(Text)
+		x.textSrc = make(map[int]map[int]string)
+		x.textTitles = make(map[int]string)
+		if x.lang != English {
+			x.loadText(English)
+		}
+		x.loadText(x.lang)
+	})
+	return x.text
+}
 
-		names := make(sort.StringSlice, 0)
-		for fn := range x.xf.f["addon/t"] {
-			// Just english for now.
-			if !strings.HasSuffix(fn, "L044.xml") {
-				continue
-			}
-			names = append(names, fn)
+// Page ids in the t/ files have an offset that depends on who wrote
+// them, 300000 and up are from mods. The game strips it, so do we.
+var textPageOffsets = []int{0, 300000, 350000, 380000, 600000}
+
+// textPage maps the page id of a t/ file to the page id the game
+// looks it up by.
+func textPage(raw int) (int, bool) {
+	for i := len(textPageOffsets) - 2; i >= 0; i-- {
+		if raw >= textPageOffsets[i] && raw < textPageOffsets[i+1] {
+			return raw - textPageOffsets[i], true
 		}
-		names.Sort()
-		for _, fn := range names {
-			// Just english for now.
-			if !strings.HasSuffix(fn, "L044.xml") {
-				continue
-			}
-			f := x.xf.Open("addon/t/" + fn)
-			defer f.Close()
-			d := xml.NewDecoder(f)
-			t := TextFile{}
-			d.Decode(&t)
-
-			merge := func(min, max int) {
-				for pi := range t.Pages {
-					px := &t.Pages[pi]
-					pid := px.Id
-					if pid >= max || pid < min {
-						continue
-					}
-					pid -= min
-					if _, ok := x.text[pid]; !ok {
-						x.text[pid] = make(map[int]string, len(px.T))
-					}
-					for ti := range px.T {
-						tx := &px.T[ti]
-						x.text[pid][tx.Id] = tx.Value
-					}
-				}
+	}
+	return 0, false
+}
+
+// textFiles returns the resolved names of the t/ files for lang in
+// the order they are loaded.
+func (x *X) textFiles(lang int) []string {
+	names := make(sort.StringSlice, 0)
+	suffix := fmt.Sprintf("-L%03d.xml", lang)
+	for _, fn := range x.xf.files("addon/t") {
+		if !strings.HasSuffix(fn, suffix) {
+			continue
+		}
+		names = append(names, fn)
+	}
+	names.Sort()
+	for i := range names {
+		names[i] = x.xf.resolve("addon/t/" + names[i])
+	}
+	return names
+}
 
+func (x *X) readText(src string) (TextFile, error) {
+	t := TextFile{}
+	f, err := x.xf.Open(src)
+	if err != nil {
+		return t, err
+	}
+	defer f.Close()
+	d := xml.NewDecoder(f)
+	d.Decode(&t)
+	return t, nil
+}
+
+func (x *X) loadText(lang int) {
+	for _, src := range x.textFiles(lang) {
+		t, err := x.readText(src)
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		// Lower offsets first, so that mods win.
+		for _, min := range textPageOffsets[:len(textPageOffsets)-1] {
+			for pi := range t.Pages {
+				px := &t.Pages[pi]
+				pid, ok := textPage(px.Id)
+				if !ok || px.Id-pid != min {
+					continue
+				}
+				if _, ok := x.text[pid]; !ok {
+					x.text[pid] = make(map[int]string, len(px.T))
+					x.textSrc[pid] = make(map[int]string, len(px.T))
+				}
+				if px.Title != "" {
+					x.textTitles[pid] = px.Title
+				}
+				for ti := range px.T {
+					tx := &px.T[ti]
+					x.text[pid][tx.Id] = tx.Value
+					x.textSrc[pid][tx.Id] = src
+				}
 			}
-			merge(0, 300000)
-			merge(300000, 350000)
-			merge(350000, 380000)
-			merge(380000, 600000)
 		}
-	})
-	return x.text
+	}
 }
 
-var reCurly = regexp.MustCompile("\\{([[:digit:]]+),([[:digit:]]+)\\}")
-var reParen = regexp.MustCompile("\\(.*\\)")
+type TextPage struct {
+	ID    int
+	Title string
+	Count int
+}
 
-func (t Text) Get(pid, tid int) (string, error) {
-	if t[pid] == nil {
-		return "", fmt.Errorf("Bad page: %d", pid)
+type TextEntry struct {
+	Page, ID int
+	Raw      string        // As it is in the file.
+	Text     string        // What Get returns.
+	HTML     template.HTML // What GetHTML returns.
+	Source   string        // The file it came from.
+}
+
+// All the text pages, sorted by id.
+func (x *X) TextPages() []TextPage {
+	t := x.GetText()
+	ret := make([]TextPage, 0, len(t))
+	for pid := range t {
+		ret = append(ret, TextPage{ID: pid, Title: x.textTitles[pid], Count: len(t[pid])})
 	}
-	s, ok := t[pid][tid]
-	if !ok {
-		// This can't be fatal (yet?).
-		log.Printf("bad string ID: %d/%d", pid, tid)
-		return fmt.Sprintf("bad string %d,%d", pid, tid), nil
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ID < ret[j].ID })
+	return ret
+}
+
+func (x *X) textEntry(pid, tid int) TextEntry {
+	t := x.GetText()
+	e := TextEntry{Page: pid, ID: tid, Raw: t[pid][tid], Source: x.textSrc[pid][tid]}
+	var err error
+	if e.Text, err = t.Get(pid, tid); err != nil {
+		e.Text = err.Error()
 	}
-	for {
-		if m := reCurly.FindStringSubmatch(s); len(m) > 0 {
-			pid, err := strconv.Atoi(m[1])
-			if err != nil {
-				return "", fmt.Errorf("Bad page id: %v", m[1])
-			}
-			tid, err := strconv.Atoi(m[2])
-			if err != nil {
-				return "", fmt.Errorf("Bad text id: %v", m[2])
-			}
-			repl, err := t.Get(pid, tid)
-			if err != nil {
-				return "", err
+	if e.HTML, err = t.GetHTML(pid, tid); err != nil {
+		e.HTML = template.HTML(template.HTMLEscapeString(err.Error()))
+	}
+	return e
+}
+
+func sortedIDs(m map[int]string) []int {
+	ids := make([]int, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// All the entries of a text page, sorted by id.
+func (x *X) TextPage(pid int) []TextEntry {
+	ret := []TextEntry{}
+	for _, tid := range sortedIDs(x.GetText()[pid]) {
+		ret = append(ret, x.textEntry(pid, tid))
+	}
+	return ret
+}
+
+var reTextID = regexp.MustCompile(`^\{?\s*([0-9]+)\s*[,/]\s*([0-9]+)\s*\}?$`)
+
+// SearchText finds at most max entries that contain q, ignoring case.
+// q can also be a text id like "17/12345" or "{17,12345}".
+func (x *X) SearchText(q string, max int) []TextEntry {
+	t := x.GetText()
+	if m := reTextID.FindStringSubmatch(q); m != nil {
+		pid, _ := strconv.Atoi(m[1])
+		tid, _ := strconv.Atoi(m[2])
+		if _, ok := t[pid][tid]; ok {
+			return []TextEntry{x.textEntry(pid, tid)}
+		}
+	}
+	q = strings.ToLower(q)
+	ret := []TextEntry{}
+	for _, p := range x.TextPages() {
+		for _, tid := range sortedIDs(t[p.ID]) {
+			plain, _ := t.Get(p.ID, tid)
+			if strings.Contains(strings.ToLower(plain), q) || strings.Contains(strings.ToLower(t[p.ID][tid]), q) {
+				ret = append(ret, x.textEntry(p.ID, tid))
+				if len(ret) >= max {
+					return ret
+				}
 			}
-			s = strings.Replace(s, m[0], repl, -1)
-		} else {
-			break
 		}
 	}
-	return strings.TrimSpace(reParen.ReplaceAllString(s, "")), nil
+	return ret
+}
+
+var reTextFile = regexp.MustCompile(`^[0-9]+-L([0-9]+)\.xml$`)
+
+// Languages returns the language codes that there are text files
+// for, like 44 for English or 49 for German.
+func (x *X) Languages() []int {
+	seen := make(map[int]bool)
+	ret := []int{}
+	for _, fn := range x.xf.files("addon/t") {
+		m := reTextFile.FindStringSubmatch(fn)
+		if m == nil {
+			continue
+		}
+		l, _ := strconv.Atoi(m[1])
+		if !seen[l] {
+			seen[l] = true
+			ret = append(ret, l)
+		}
+	}
+	sort.Ints(ret)
+	return ret
+}
+
+// Get returns the text with references expanded, comments removed
+// and without any formatting.
+func (t Text) Get(pid, tid int) (string, error) {
+	ns, err := t.expand(pid, tid, nil)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(plainText(ns)), nil
+}
+
+// GetHTML is Get with newlines and colours in HTML.
+func (t Text) GetHTML(pid, tid int) (template.HTML, error) {
+	ns, err := t.expand(pid, tid, nil)
+	if err != nil {
+		return "", err
+	}
+	return htmlText(ns), nil
 }
@@ -0,0 +1,196 @@
+package xt
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestLanguages(t *testing.T) {
+	x := testX(t, map[string]string{
+		"addon/t/0001-L044.xml": testText,
+		"t/0001-L049.xml": `<?xml version="1.0" encoding="UTF-8" ?>
+<language id="49">
+<page id="17">
+<t id="100">Libelle</t>
+</page>
+</language>
+`,
+		"addon/types/TMissiles.txt": "22;1;\n" +
+			testLine(reflect.TypeOf(TMissile{}), map[string]string{"Index": "SG_MISSILE_LIGHT", "Description": "100", "Speed": "500", "ObjectID": "SS_M"}),
+	})
+	if l := x.Languages(); !reflect.DeepEqual(l, []int{44, 49}) {
+		t.Errorf("languages: %v", l)
+	}
+	de := x.WithLanguage(49)
+	if de.Language() != 49 || x.WithLanguage(49) != de || de.WithLanguage(English) != x {
+		t.Errorf("views aren't cached")
+	}
+	if s, _ := de.GetText().Get(17, 101); s != "Energy Cells" {
+		t.Errorf("no fallback to English: %q", s)
+	}
+	if d := de.GetMissiles()[0].Description; d != "Libelle" {
+		t.Errorf("German missile: %q", d)
+	}
+	if d := x.GetMissiles()[0].Description; d != "Dragonfly" {
+		t.Errorf("English missile: %q", d)
+	}
+}
+
+func TestTextMarkup(t *testing.T) {
+	txt := Text{
+		1: {
+			1:  `Hello (comment (nested) still comment) \(world\)`,
+			2:  `{1,3} and { 1 , 1 }`,
+			3:  `\033Rred\033X\nplain <b>`,
+			4:  `{1,5}`,
+			5:  `{1,4}`,
+			6:  `{not a ref} \{1,1\} \\`,
+			7:  `{1,7}`,
+			8:  `open (paren stays`,
+			9:  `a (b (c) d`,
+			10: `no colour\033`,
+		},
+	}
+	for _, tc := range []struct {
+		id          int
+		plain, html string
+	}{
+		{1, "Hello  (world)", "Hello  (world)"},
+		{2, "red\nplain <b> and Hello  (world)", `<span style="color:red">red</span><br />plain &lt;b&gt; and Hello  (world)`},
+		{6, `{not a ref} {1,1} \`, `{not a ref} {1,1} \`},
+		// Like the old regexp did it.
+		{8, "open (paren stays", "open (paren stays"},
+		{9, "a  d", "a  d"},
+		{10, `no colour\033`, `no colour\033`},
+	} {
+		if s, err := txt.Get(1, tc.id); err != nil || s != tc.plain {
+			t.Errorf("Get(1, %d) = %q, %v, want %q", tc.id, s, err, tc.plain)
+		}
+		if s, err := txt.GetHTML(1, tc.id); err != nil || string(s) != tc.html {
+			t.Errorf("GetHTML(1, %d) = %q, %v, want %q", tc.id, s, err, tc.html)
+		}
+	}
+	for _, id := range []int{4, 7} {
+		if _, err := txt.Get(1, id); err == nil {
+			t.Errorf("no error for cycle in %d", id)
+		}
+	}
+}
+
+func TestSearchText(t *testing.T) {
+	x := testX(t, map[string]string{
+		"addon/t/0001-L044.xml": testText,
+	})
+	if p := x.TextPages(); len(p) != 1 || p[0].ID != 17 || p[0].Count != 2 || p[0].Title != "Boardcomp. objects" {
+		t.Errorf("pages: %v", p)
+	}
+	for q, want := range map[string]int{"energy": 101, "17/100": 100, "{17,101}": 101} {
+		e := x.SearchText(q, 10)
+		if len(e) != 1 || e[0].ID != want || e[0].Source != "addon/t/0001-L044.xml" {
+			t.Errorf("search %q: %v", q, e)
+		}
+	}
+}
+
+func TestTextTrans(t *testing.T) {
+	items := []TextItem{
+		{Page: 17, ID: 100, Source: "Dragonfly", Text: "Libelle"},
+		{Page: 17, ID: 101, Source: "Say \"hi\"\\n<b>", Text: "Sag \"hallo\"\\n<b>\nzwei, Zeilen"},
+		{Page: 17, ID: 102, Source: "Untranslated"},
+		{Page: 300, ID: 1, Text: "Nur Deutsch"},
+	}
+	var po, csv bytes.Buffer
+	if err := WritePO(&po, items, 49); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteTextCSV(&csv, items); err != nil {
+		t.Fatal(err)
+	}
+	fromPO, err := ReadPO(&po)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromCSV, err := ReadTextCSV(&csv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, got := range [][]TextItem{fromPO, fromCSV} {
+		if len(got) != len(items) {
+			t.Fatalf("got %d items, want %d", len(got), len(items))
+		}
+		for i := range items {
+			if got[i].Page != items[i].Page || got[i].ID != items[i].ID || got[i].Text != items[i].Text {
+				t.Errorf("item %d: got %+v, want %+v", i, got[i], items[i])
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	if err := NewTextFile(fromPO, 49, map[int]string{17: "Boring & stuff"}).Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	tf, err := ReadTextFile(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tf.Pages) != 2 || tf.Pages[0].Title != "Boring & stuff" || len(tf.Pages[0].T) != 2 {
+		t.Fatalf("bad text file: %+v", tf)
+	}
+	if v := tf.Pages[0].T[1].Value; v != items[1].Text {
+		t.Errorf("got %q, want %q", v, items[1].Text)
+	}
+}
+
+func TestTextTransOffset(t *testing.T) {
+	x := testX(t, map[string]string{
+		"addon/t/0001-L044.xml": testText,
+		"addon/t/7002-L044.xml": `<?xml version="1.0" encoding="UTF-8" ?>
+<language id="44">
+<page id="300020" title="Mod ships" descr="0">
+<t id="1">Mod ship</t>
+</page>
+</language>
+`,
+		"t/7002-L049.xml": `<?xml version="1.0" encoding="UTF-8" ?>
+<language id="49">
+<page id="300020" title="Modschiffe" descr="0">
+<t id="1">Modschiff</t>
+</page>
+</language>
+`,
+	})
+	de := x.WithLanguage(49)
+	if s, _ := de.GetText().Get(20, 1); s != "Modschiff" {
+		t.Fatalf("offset page not loaded: %q", s)
+	}
+	items := de.TextItems()
+	var po bytes.Buffer
+	if err := WritePO(&po, items, 49); err != nil {
+		t.Fatal(err)
+	}
+	items, err := ReadPO(&po)
+	if err != nil {
+		t.Fatal(err)
+	}
+	titles := make(map[int]string)
+	for _, p := range de.TextPages() {
+		titles[p.ID] = p.Title
+	}
+	var out bytes.Buffer
+	if err := NewTextFile(items, 49, titles).Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	tf, err := ReadTextFile(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tf.Pages) != 1 || tf.Pages[0].Id != 300020 || tf.Pages[0].Title != "Modschiffe" {
+		t.Fatalf("bad text file: %+v", tf)
+	}
+	got := de.FileTextItems(tf)
+	want := []TextItem{{Page: 300020, ID: 1, Source: "Mod ship", Text: "Modschiff"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,207 @@
+package xt
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The markup in t/ files:
+//
+//	{page,id}   the text from another page and id
+//	(...)       a comment, not shown, can be nested
+//	\033X       colour X, \033X goes back to the default colour
+//	\n          a newline
+//	\(, \{, ... the character itself
+
+type textKind int
+
+const (
+	textPlain textKind = iota
+	textNewline
+	textColor
+	textRef
+)
+
+type textNode struct {
+	kind     textKind
+	s        string // textPlain
+	color    byte   // textColor
+	page, id int    // textRef
+}
+
+var reRef = regexp.MustCompile(`^\{\s*([0-9]+)\s*,\s*([0-9]+)\s*\}`)
+
+func parseText(s string) []textNode {
+	ret := []textNode{}
+	var buf strings.Builder
+	flush := func() {
+		if buf.Len() != 0 {
+			ret = append(ret, textNode{kind: textPlain, s: buf.String()})
+			buf.Reset()
+		}
+	}
+	node := func(n textNode) {
+		flush()
+		ret = append(ret, n)
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && strings.HasPrefix(s[i+1:], "033"):
+			if i+4 >= len(s) {
+				// No colour after it, leave it alone.
+				buf.WriteString(s[i:])
+				i = len(s)
+				continue
+			}
+			node(textNode{kind: textColor, color: s[i+4]})
+			i += 4
+		case c == 0x1b && i+1 < len(s):
+			node(textNode{kind: textColor, color: s[i+1]})
+			i++
+		case c == '\\' && i+1 < len(s):
+			i++
+			if s[i] == 'n' {
+				node(textNode{kind: textNewline})
+			} else {
+				buf.WriteByte(s[i])
+			}
+		case c == '(':
+			end := commentEnd(s, i)
+			if end == -1 {
+				buf.WriteByte(c)
+				continue
+			}
+			i = end
+		case c == '{':
+			m := reRef.FindStringSubmatch(s[i:])
+			if m == nil {
+				buf.WriteByte(c)
+				continue
+			}
+			pid, _ := strconv.Atoi(m[1])
+			tid, _ := strconv.Atoi(m[2])
+			node(textNode{kind: textRef, page: pid, id: tid})
+			i += len(m[0]) - 1
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	flush()
+	return ret
+}
+
+// Where the comment starting at s[start] ends, at the matching ')'.
+// If the parentheses don't balance it's the last ')', -1 if there is
+// none, then it isn't a comment at all.
+func commentEnd(s string, start int) int {
+	depth, last := 0, -1
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '(':
+			depth++
+		case ')':
+			depth--
+			last = i
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return last
+}
+
+// The text with all the references replaced by what they refer to.
+// seen is the references we're in the middle of expanding.
+func (t Text) expand(pid, tid int, seen map[[2]int]bool) ([]textNode, error) {
+	if t[pid] == nil {
+		return nil, fmt.Errorf("Bad page: %d", pid)
+	}
+	s, ok := t[pid][tid]
+	if !ok {
+		// This can't be fatal (yet?).
+		log.Printf("bad string ID: %d/%d", pid, tid)
+		return []textNode{{kind: textPlain, s: fmt.Sprintf("bad string %d,%d", pid, tid)}}, nil
+	}
+	if seen == nil {
+		seen = make(map[[2]int]bool)
+	}
+	key := [2]int{pid, tid}
+	if seen[key] {
+		return nil, fmt.Errorf("text {%d,%d} refers to itself", pid, tid)
+	}
+	seen[key] = true
+	defer delete(seen, key)
+
+	ret := []textNode{}
+	for _, n := range parseText(s) {
+		if n.kind != textRef {
+			ret = append(ret, n)
+			continue
+		}
+		sub, err := t.expand(n.page, n.id, seen)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, sub...)
+	}
+	return ret, nil
+}
+
+func plainText(ns []textNode) string {
+	var b strings.Builder
+	for _, n := range ns {
+		switch n.kind {
+		case textPlain:
+			b.WriteString(n.s)
+		case textNewline:
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
+
+var textColors = map[byte]string{
+	'A': "silver",
+	'B': "blue",
+	'C': "cyan",
+	'G': "green",
+	'M': "magenta",
+	'R': "red",
+	'W': "white",
+	'Y': "yellow",
+	'Z': "gray",
+}
+
+func htmlText(ns []textNode) template.HTML {
+	var b strings.Builder
+	open := false
+	for _, n := range ns {
+		switch n.kind {
+		case textPlain:
+			b.WriteString(html.EscapeString(n.s))
+		case textNewline:
+			b.WriteString("<br />")
+		case textColor:
+			if open {
+				b.WriteString("</span>")
+				open = false
+			}
+			if c, ok := textColors[n.color]; ok {
+				fmt.Fprintf(&b, `<span style="color:%s">`, c)
+				open = true
+			}
+		}
+	}
+	if open {
+		b.WriteString("</span>")
+	}
+	return template.HTML(b.String())
+}
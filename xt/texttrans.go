@@ -0,0 +1,334 @@
+package xt
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Moving text in and out of the t/ files, for translators that would
+// rather use PO editors or spreadsheets than xml.
+
+// One string to translate. The text is raw, with all the markup.
+type TextItem struct {
+	Page, ID int
+	Source   string // The English text, if there is any.
+	Text     string // The translation, empty if there isn't one.
+}
+
+// One string as it is in a t/ file, the page id is the one in the
+// file, with the offset.
+type rawText struct {
+	page  int
+	value string
+}
+
+// Only the text from the files in one language, without the English
+// fallback. Keyed by the page and text id the game uses, so that the
+// same string in pages with different offsets compares equal.
+func (x *X) langText(lang int) map[[2]int]rawText {
+	ret := make(map[[2]int]rawText)
+	for _, src := range x.textFiles(lang) {
+		t, err := x.readText(src)
+		if err != nil {
+			continue
+		}
+		// Same order as loadText, so the same string wins.
+		for _, min := range textPageOffsets[:len(textPageOffsets)-1] {
+			for _, p := range t.Pages {
+				pid, ok := textPage(p.Id)
+				if !ok || p.Id-pid != min {
+					continue
+				}
+				for _, tx := range p.T {
+					ret[[2]int{pid, tx.Id}] = rawText{p.Id, tx.Value}
+				}
+			}
+		}
+	}
+	return ret
+}
+
+// TextItems returns all the text of the installation in the language
+// of x next to the English text. Strings that only exist in the
+// language of x have an empty Source. Pages have the ids of the t/
+// files, with the offset, so that NewTextFile puts them back where
+// they came from.
+func (x *X) TextItems() []TextItem {
+	en := x.langText(English)
+	tr := en
+	if x.lang != English {
+		tr = x.langText(x.lang)
+	}
+	items := make(map[[2]int]*TextItem)
+	for _, t := range []map[[2]int]rawText{en, tr} {
+		for k, v := range t {
+			page := v.page
+			if r, ok := tr[k]; ok {
+				page = r.page
+			}
+			items[k] = &TextItem{Page: page, ID: k[1], Source: en[k].value, Text: tr[k].value}
+		}
+	}
+	return sortItems(items)
+}
+
+// FileTextItems returns the text in one t/ file, with the English text
+// of the installation as the Source. Pages keep the ids of the file.
+func (x *X) FileTextItems(tf *TextFile) []TextItem {
+	en := x.langText(English)
+	items := make(map[[2]int]*TextItem)
+	for _, p := range tf.Pages {
+		pid, _ := textPage(p.Id)
+		for _, t := range p.T {
+			items[[2]int{p.Id, t.Id}] = &TextItem{Page: p.Id, ID: t.Id, Source: en[[2]int{pid, t.Id}].value, Text: t.Value}
+		}
+	}
+	return sortItems(items)
+}
+
+func sortItems(m map[[2]int]*TextItem) []TextItem {
+	ret := make([]TextItem, 0, len(m))
+	for _, it := range m {
+		ret = append(ret, *it)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Page != ret[j].Page {
+			return ret[i].Page < ret[j].Page
+		}
+		return ret[i].ID < ret[j].ID
+	})
+	return ret
+}
+
+// ReadTextFile parses a t/ file.
+func ReadTextFile(r io.Reader) (*TextFile, error) {
+	tf := &TextFile{}
+	if err := xml.NewDecoder(r).Decode(tf); err != nil {
+		return nil, err
+	}
+	return tf, nil
+}
+
+// NewTextFile makes a t/ file for lang from translated items. Items
+// without a translation are left out so that the game falls back to
+// English for them. Page ids are written as they are, titles are
+// looked up by the page id without the offset, like TextPages has
+// them, and can be nil.
+func NewTextFile(items []TextItem, lang int, titles map[int]string) *TextFile {
+	tf := &TextFile{}
+	tf.Language.Id = lang
+	pages := make(map[int]*PageXML)
+	order := []int{}
+	for _, it := range items {
+		if it.Text == "" {
+			continue
+		}
+		p := pages[it.Page]
+		if p == nil {
+			pid, _ := textPage(it.Page)
+			p = &PageXML{Id: it.Page, Title: titles[pid]}
+			pages[it.Page] = p
+			order = append(order, it.Page)
+		}
+		p.T = append(p.T, struct {
+			Id    int    `xml:"id,attr"`
+			Value string `xml:",chardata"`
+		}{it.ID, it.Text})
+	}
+	sort.Ints(order)
+	for _, pid := range order {
+		tf.Pages = append(tf.Pages, *pages[pid])
+	}
+	return tf
+}
+
+// Write a t/ file the way the game files look.
+func (tf *TextFile) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	esc := func(s string) string {
+		var b strings.Builder
+		xml.EscapeText(&b, []byte(s))
+		return b.String()
+	}
+	fmt.Fprintf(bw, "<?xml version=\"1.0\" encoding=\"UTF-8\" ?>\n<language id=\"%d\">\n", tf.Language.Id)
+	for _, p := range tf.Pages {
+		descr := p.Descr
+		if descr == "" {
+			descr = "0"
+		}
+		fmt.Fprintf(bw, "<page id=\"%d\" title=\"%s\" descr=\"%s\">\n", p.Id, esc(p.Title), esc(descr))
+		for _, t := range p.T {
+			fmt.Fprintf(bw, "<t id=\"%d\">%s</t>\n", t.Id, esc(t.Value))
+		}
+		fmt.Fprintf(bw, "</page>\n")
+	}
+	fmt.Fprintf(bw, "</language>\n")
+	return bw.Flush()
+}
+
+var csvHeader = []string{"page", "id", "english", "text"}
+
+// WriteTextCSV writes the items as csv with a header.
+func WriteTextCSV(w io.Writer, items []TextItem) error {
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader)
+	for _, it := range items {
+		cw.Write([]string{strconv.Itoa(it.Page), strconv.Itoa(it.ID), it.Source, it.Text})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ReadTextCSV reads what WriteTextCSV wrote. The columns are found by
+// the header, only page, id and text are needed.
+func ReadTextCSV(r io.Reader) ([]TextItem, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	hdr, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	col := make(map[string]int)
+	for i, h := range hdr {
+		col[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	for _, c := range []string{"page", "id", "text"} {
+		if _, ok := col[c]; !ok {
+			return nil, fmt.Errorf("csv: no %s column", c)
+		}
+	}
+	get := func(rec []string, c string) string {
+		if i, ok := col[c]; ok && i < len(rec) {
+			return rec[i]
+		}
+		return ""
+	}
+	ret := []TextItem{}
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return ret, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		it := TextItem{Source: get(rec, "english"), Text: get(rec, "text")}
+		if it.Page, err = strconv.Atoi(get(rec, "page")); err != nil {
+			return nil, fmt.Errorf("csv line %d: bad page: %v", line, err)
+		}
+		if it.ID, err = strconv.Atoi(get(rec, "id")); err != nil {
+			return nil, fmt.Errorf("csv line %d: bad id: %v", line, err)
+		}
+		ret = append(ret, it)
+	}
+}
+
+// PO files are keyed by msgctxt "page/id", msgid is the English text
+// and msgstr the translation.
+
+var poEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`)
+
+// WritePO writes the items as a gettext PO file for lang.
+func WritePO(w io.Writer, items []TextItem, lang int) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "msgid \"\"\nmsgstr \"\"\n\"Content-Type: text/plain; charset=UTF-8\\n\"\n\"X-X3-Language: %d\\n\"\n", lang)
+	for _, it := range items {
+		src := it.Source
+		if src == "" {
+			// msgid can't be empty.
+			src = it.Text
+		}
+		fmt.Fprintf(bw, "\nmsgctxt \"%d/%d\"\nmsgid \"%s\"\nmsgstr \"%s\"\n", it.Page, it.ID, poEscaper.Replace(src), poEscaper.Replace(it.Text))
+	}
+	return bw.Flush()
+}
+
+func poUnquote(s string) (string, error) {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return "", fmt.Errorf("not a string: %s", s)
+	}
+	s = s[1 : len(s)-1]
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// ReadPO reads the entries of a PO file that have a "page/id"
+// msgctxt, other entries (like the header) are ignored.
+func ReadPO(r io.Reader) ([]TextItem, error) {
+	ret := []TextItem{}
+	var ctxt, id, str string
+	var cur *string
+	flush := func() error {
+		if ctxt != "" {
+			it := TextItem{Source: id, Text: str}
+			if _, err := fmt.Sscanf(ctxt, "%d/%d", &it.Page, &it.ID); err != nil {
+				return fmt.Errorf("po: bad msgctxt %q", ctxt)
+			}
+			ret = append(ret, it)
+		}
+		ctxt, id, str, cur = "", "", "", nil
+		return nil
+	}
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		var rest string
+		switch {
+		case line == "" || line[0] == '#':
+			continue
+		case strings.HasPrefix(line, "msgctxt "):
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			cur, rest = &ctxt, line[len("msgctxt "):]
+		case strings.HasPrefix(line, "msgid "):
+			if cur != &ctxt {
+				if err := flush(); err != nil {
+					return nil, err
+				}
+			}
+			cur, rest = &id, line[len("msgid "):]
+		case strings.HasPrefix(line, "msgstr "):
+			cur, rest = &str, line[len("msgstr "):]
+		case line[0] == '"' && cur != nil:
+			rest = line
+		default:
+			return nil, fmt.Errorf("po line %d: can't parse: %s", n, line)
+		}
+		v, err := poUnquote(rest)
+		if err != nil {
+			return nil, fmt.Errorf("po line %d: %v", n, err)
+		}
+		*cur += v
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
@@ -1,10 +1,11 @@
 package xt
 
 import (
+	"bytes"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"reflect"
 	"strconv"
 	"strings"
@@ -15,70 +16,259 @@ type tParser struct {
 	lastTag string
 	t       Text
 	x       *X
+	path    string
+	raw     *rawRecord
+	unres   []error // ObjectIDs that point to nothing.
 }
 
-func (x *X) tparse(f io.Reader, slicei interface{}) {
-	x.tparsev(f, reflect.Indirect(reflect.ValueOf(slicei)))
+// What a record looked like in the file. This is what lets us write
+// back the same page ids and indexes that we resolved when parsing.
+type rawRecord struct {
+	tok    map[string]string // Field path ("GunGroup[0].Gun[1].BodyID") to the value in the file.
+	rest   []string          // Whatever was left on the line after the last field.
+	line   int               // Where the record is in the file.
+	before []string          // Comment and empty lines between the previous record and this one.
 }
 
-func (x *X) tparsev(f io.Reader, slicev reflect.Value) {
+// What the file looked like outside of the records.
+type rawFile struct {
+	ver  string   // From the header.
+	head []string // Comment and empty lines before the header.
+	tail []string // Comment and empty lines after the last record.
+}
+
+// Embedded in the records of the types/ files, so that a record
+// carries what it looked like in the file wherever it gets copied.
+// Records that are deleted, inserted or moved around in a slice still
+// find their own values when written back.
+type rawRef struct {
+	raw *rawRecord
+}
+
+func (r *rawRef) fileRaw() *rawRecord      { return r.raw }
+func (r *rawRef) setFileRaw(rr *rawRecord) { r.raw = rr }
+
+type hasRaw interface {
+	fileRaw() *rawRecord
+	setFileRaw(*rawRecord)
+}
+
+var rawRefType = reflect.TypeOf(rawRef{})
+
+// The raw record of v, a record in a slice, nil if it wasn't parsed
+// from a file.
+func rawOf(v reflect.Value) *rawRecord {
+	if !v.CanAddr() {
+		return nil
+	}
+	if h, ok := v.Addr().Interface().(hasRaw); ok {
+		return h.fileRaw()
+	}
+	return nil
+}
+
+func setRaw(v reflect.Value, raw *rawRecord) {
+	if h, ok := v.Addr().Interface().(hasRaw); ok {
+		h.setFileRaw(raw)
+	}
+}
+
+// ParseError is a problem with one value in a types/*.txt file.
+type ParseError struct {
+	File   string
+	Line   int
+	Record int    // -1 for the header.
+	Field  string // Path like "GunGroup[2].Gun[0].BodyID", empty if the problem is with the whole record.
+	Token  string // The offending value.
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	s := fmt.Sprintf("%s:%d: ", e.File, e.Line)
+	if e.Record >= 0 {
+		s += fmt.Sprintf("record %d: ", e.Record)
+	}
+	if e.Field != "" {
+		s += e.Field + ": "
+	}
+	if e.Token != "" {
+		s += fmt.Sprintf("%q: ", e.Token)
+	}
+	return s + e.Err.Error()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+var errNotConsumed = errors.New("record not fully consumed")
+
+var errUnresolved = errors.New("no record with this ObjectID")
+
+func (x *X) tparse(f io.Reader, slicei interface{}) error {
+	_, errs, _ := x.tparsev("", f, reflect.Indirect(reflect.ValueOf(slicei)), false)
+	return errors.Join(errs...)
+}
+
+// Returns what the file looked like around the records and what went
+// wrong. Records get what they looked like in the file in their
+// rawRef, with the comments above them. Unless
+// lenient we stop at the first bad record and only keep the ones
+// before it, otherwise bad records are left zeroed (to keep the
+// indexes of the others) and we keep going. ObjectID references that
+// point to nothing don't make a record bad, the field is left nil and
+// the reference is in unres.
+func (x *X) tparsev(fn string, f io.Reader, slicev reflect.Value, lenient bool) (file *rawFile, errs, unres []error) {
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, []error{&ParseError{File: fn, Record: -1, Err: err}}, nil
+	}
 	// It's not really a csv file, but this works, so why not.
-	r := csv.NewReader(f)
+	r := csv.NewReader(bytes.NewReader(data))
 	r.Comment = '/'
 	r.Comma = ';'
 
+	// The csv reader skips comments and empty lines, we pick them up
+	// from the lines between the records.
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	next := 1
+	skipped := func(upto int) []string {
+		var ret []string
+		for ; next < upto && next <= len(lines); next++ {
+			ret = append(ret, strings.TrimSuffix(lines[next-1], "\r"))
+		}
+		return ret
+	}
+	before := func(rec []string) []string {
+		start, _ := r.FieldPos(0)
+		end, _ := r.FieldPos(len(rec) - 1)
+		ret := skipped(start)
+		next = end + 1
+		return ret
+	}
+
+	perr := func(rn int, err error) error {
+		line, _ := r.FieldPos(0)
+		if pe, ok := err.(*ParseError); ok {
+			pe.File, pe.Line, pe.Record = fn, line, rn
+			return pe
+		}
+		if ce, ok := err.(*csv.ParseError); ok {
+			line = ce.Line
+			err = ce.Err
+		}
+		return &ParseError{File: fn, Line: line, Record: rn, Err: err}
+	}
+
 	rec, err := r.Read()
 	if err != nil {
-		log.Fatal(err)
+		return nil, []error{perr(-1, err)}, nil
 	}
 	inf := struct {
 		Ver  string
 		Nrec int
 	}{}
+	file = &rawFile{head: before(rec)}
 	t := tParser{rec: rec, t: x.GetText(), x: x}
-	t.parseAll(&inf)
+	if err := t.parseAll(&inf); err != nil {
+		return nil, []error{perr(-1, err)}, nil
+	}
+	file.ver = inf.Ver
 
 	slicev.Set(reflect.MakeSlice(slicev.Type(), inf.Nrec, inf.Nrec))
 	for i := 0; i < inf.Nrec; i++ {
+		raw := &rawRecord{tok: make(map[string]string)}
 		r.FieldsPerRecord = 0
 		rec, err := r.Read()
-		if err != nil {
-			log.Fatal(err)
+		if err == io.EOF {
+			errs = append(errs, perr(i, fmt.Errorf("file ends after %d of %d records", i, inf.Nrec)))
+			if !lenient {
+				slicev.Set(slicev.Slice(0, i))
+			}
+			break
 		}
-		// XXX - this might be a comment/empty line.
-		if len(rec) == 1 {
-			continue
+		if err == nil {
+			var u []error
+			raw.line, _ = r.FieldPos(0)
+			raw.before = before(rec)
+			u, err = x.trecord(rec, slicev.Index(i), raw)
+			for _, e := range u {
+				unres = append(unres, perr(i, e))
+			}
 		}
-		t := tParser{rec: rec, t: x.GetText(), x: x}
-		err = t.pvalue(slicev.Index(i))
 		if err != nil {
-			log.Print("Line: ", rec)
-			log.Fatal(err)
-		}
-		if len(t.rec) == 1 && t.rec[0] == "" {
-			t.rec = t.rec[1:]
-		}
-		if len(t.rec) != 0 {
-			trimmed := strings.TrimLeft(t.rec[0], " \t")
-			if trimmed != "" && trimmed[0] != '/' {
-				log.Fatalf("record not fully consumed: %v %v", t.rec, len(t.rec))
+			errs = append(errs, perr(i, err))
+			if !lenient {
+				slicev.Set(slicev.Slice(0, i))
+				break
 			}
+			slicev.Index(i).Set(reflect.Zero(slicev.Type().Elem()))
+			raw = &rawRecord{tok: make(map[string]string), rest: rec, line: raw.line, before: raw.before}
+		}
+		setRaw(slicev.Index(i), raw)
+	}
+	file.tail = skipped(len(lines) + 1)
+	return file, errs, unres
+}
+
+func (x *X) trecord(rec []string, v reflect.Value, raw *rawRecord) ([]error, error) {
+	// XXX - this might be a comment/empty line.
+	if len(rec) == 1 {
+		raw.rest = rec
+		return nil, nil
+	}
+	t := tParser{rec: rec, t: x.GetText(), x: x, raw: raw}
+	if err := t.pvalue(v); err != nil {
+		return nil, err
+	}
+	raw.rest = t.rec
+	if len(t.rec) == 1 && t.rec[0] == "" {
+		t.rec = t.rec[1:]
+	}
+	if len(t.rec) != 0 {
+		trimmed := strings.TrimLeft(t.rec[0], " \t")
+		if trimmed != "" && trimmed[0] != '/' {
+			return nil, &ParseError{Token: strings.Join(t.rec, ";"), Err: errNotConsumed}
 		}
 	}
+	return t.unres, nil
 }
 
-func (t *tParser) parseAll(data interface{}) {
+// Consume the next value, remembering what it was.
+func (t *tParser) take() string {
+	s := t.rec[0]
+	t.rec = t.rec[1:]
+	if t.raw != nil {
+		t.raw.tok[t.path] = s
+	}
+	return s
+}
+
+// Where in the record something went wrong.
+func (t *tParser) fail(err error) error {
+	if _, ok := err.(*ParseError); ok {
+		return err
+	}
+	pe := &ParseError{Field: t.path, Err: err}
+	if len(t.rec) != 0 {
+		pe.Token = t.rec[0]
+	}
+	return pe
+}
+
+func (t *tParser) parseAll(data interface{}) error {
 	err := t.pvalue(reflect.Indirect(reflect.ValueOf(data)))
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	if len(t.rec) == 1 && t.rec[0] == "" {
 		t.rec = t.rec[1:]
 	}
 	if len(t.rec) != 0 {
-		log.Fatalf("record not fully consumed: %v %v", t.rec, len(t.rec))
+		return &ParseError{Token: strings.Join(t.rec, ";"), Err: errNotConsumed}
 	}
+	return nil
 }
 
 func (t *tParser) pint(v reflect.Value) error {
@@ -87,7 +277,18 @@ func (t *tParser) pint(v reflect.Value) error {
 		return err
 	}
 	v.SetInt(int64(n))
-	t.rec = t.rec[1:]
+	t.take()
+	return nil
+}
+
+// Masks. They are 32 bits in the game, -1 is all of them set.
+func (t *tParser) puint(v reflect.Value) error {
+	n, err := strconv.ParseInt(t.rec[0], 10, 64)
+	if err != nil {
+		return err
+	}
+	v.SetUint(uint64(n) & (1<<maskBits - 1))
+	t.take()
 	return nil
 }
 
@@ -97,7 +298,7 @@ func (t *tParser) pfloat(v reflect.Value) error {
 		return err
 	}
 	v.SetFloat(n)
-	t.rec = t.rec[1:]
+	t.take()
 	return nil
 }
 
@@ -119,7 +320,7 @@ func (t *tParser) pstring(v reflect.Value) error {
 		tags := tagParse(t.lastTag)
 		if tags["page"] != "" {
 			if t.rec[0] == "0" || t.rec[0] == tags["ignore"] {
-				t.rec = t.rec[1:]
+				t.take()
 				v.SetString("")
 				return nil
 			}
@@ -140,7 +341,7 @@ func (t *tParser) pstring(v reflect.Value) error {
 				return fmt.Errorf("Bad string id: %v", t.rec[0])
 			}
 			tid += off
-			t.rec = t.rec[1:]
+			t.take()
 			s, err := t.t.Get(pid, tid)
 			if err != nil {
 				return err
@@ -149,35 +350,54 @@ func (t *tParser) pstring(v reflect.Value) error {
 			return nil
 		}
 	}
-	v.SetString(t.rec[0])
-	t.rec = t.rec[1:]
+	v.SetString(t.take())
 	return nil
 }
 
 func (t *tParser) parray(v reflect.Value) error {
+	prefix := t.path
+	defer func() { t.path = prefix }()
 	for i := 0; i < v.Len(); i++ {
 		var err error
+		t.path = fmt.Sprintf("%s[%d]", prefix, i)
 		err = t.pvalue(v.Index(i))
 		if err != nil {
-			return fmt.Errorf("Array field (%d): %v", i, err)
+			return err
 		}
 	}
 	return nil
 }
 
+// The path of a struct field the way typediff names them, embedded
+// structs don't add anything.
+func fieldPath(prefix string, sf reflect.StructField) string {
+	switch {
+	case sf.Anonymous:
+		return prefix
+	case prefix == "":
+		return sf.Name
+	}
+	return prefix + "." + sf.Name
+}
+
 func (t *tParser) pstruct(v reflect.Value) error {
+	prefix := t.path
+	defer func() { t.path = prefix }()
 	for i := 0; i < v.NumField(); i++ {
 		fv := v.Field(i)
 		sf := v.Type().Field(i)
+		if sf.Type == rawRefType {
+			continue
+		}
 		t.lastTag = sf.Tag.Get("x3t")
+		t.path = fieldPath(prefix, sf)
 		if len(t.rec) == 0 {
 			// It appears that it's legal to just forget about
 			// the last few values if we don't care about them.
 			return nil
 		}
-		err := t.pvalue(fv)
-		if err != nil {
-			return fmt.Errorf("Parse Field (%s): %v", v.Type().Field(i).Name, err)
+		if err := t.pvalue(fv); err != nil {
+			return t.fail(err)
 		}
 	}
 	return nil
@@ -187,14 +407,17 @@ func (t *tParser) pslice(v reflect.Value) error {
 	// Slices are prefixed with a length
 	l, err := strconv.Atoi(t.rec[0])
 	if err != nil {
-		return fmt.Errorf("slice length: %v", err)
+		return t.fail(fmt.Errorf("slice length: %v", err))
 	}
-	t.rec = t.rec[1:]
+	t.take()
+	prefix := t.path
+	defer func() { t.path = prefix }()
 	v.Set(reflect.MakeSlice(v.Type(), l, l))
 	for i := 0; i < l; i++ {
+		t.path = fmt.Sprintf("%s[%d]", prefix, i)
 		err := t.pvalue(v.Index(i))
 		if err != nil {
-			return fmt.Errorf("slice field (%d): %v", i, err)
+			return err
 		}
 	}
 	return nil
@@ -210,7 +433,15 @@ func (t *tParser) pptr(v reflect.Value) error {
 		if err != nil {
 			return err
 		}
-		t.rec = t.rec[1:]
+		if !valref.IsValid() {
+			if tags["index"] != "true" {
+				t.unres = append(t.unres, &ParseError{Field: t.path, Token: t.rec[0], Err: errUnresolved})
+			}
+			valref = reflect.Zero(v.Type())
+		} else if !valref.Type().AssignableTo(v.Type()) {
+			return fmt.Errorf("%v can't point to %v", v.Type(), valref.Type())
+		}
+		t.take()
 		v.Set(valref)
 		return nil
 	} else {
@@ -219,11 +450,25 @@ func (t *tParser) pptr(v reflect.Value) error {
 }
 
 func (t *tParser) pvalue(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Array, reflect.Struct:
+	default:
+		if len(t.rec) == 0 {
+			return t.fail(errors.New("record too short"))
+		}
+	}
+	if err := t.pkind(v); err != nil {
+		return t.fail(err)
+	}
+	return nil
+}
+
+func (t *tParser) pkind(v reflect.Value) error {
 	switch v.Kind() {
 	case reflect.Int:
 		return t.pint(v)
 	case reflect.Uint:
-		return t.pint(v)
+		return t.puint(v)
 	case reflect.Float64:
 		return t.pfloat(v)
 	case reflect.String:
@@ -0,0 +1,342 @@
+package xt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testText = `<?xml version="1.0" encoding="UTF-8" ?>
+<language id="44">
+<page id="17" title="Boardcomp. objects" descr="0">
+<t id="100">Dragonfly</t>
+<t id="101">Energy Cells</t>
+</page>
+</language>
+`
+
+func testX(t *testing.T, files map[string]string) *X {
+	t.Helper()
+	inst := t.TempDir()
+	writeFiles(t, inst, files)
+	x, err := NewX(inst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return x
+}
+
+func TestParseTypes(t *testing.T) {
+	x := testX(t, map[string]string{
+		"addon/t/0001-L044.xml": testText,
+		"addon/types/TMissiles.txt": "// comment\n" +
+			"22;1;\n" +
+			testLine(reflect.TypeOf(TMissile{}), map[string]string{
+				"BodyFile": "b\\m", "Index": "SG_MISSILE_LIGHT", "Description": "100",
+				"Speed": "500", "Lifetime": "8000", "Damage": "1200", "ObjectID": "SS_WARE_MISSILE_DRAGONFLY"}),
+		"addon/types/TWareE.txt": "22;1;\n" +
+			"b;0;0;0.5;0;SG_WARE_ENERGY;101;1;2;3;4;0;6;7;8;9;SS_WARE_ENERGY;\n",
+		"addon/types/TFactories.txt": "22;1;\n" +
+			testLine(reflect.TypeOf(TFactory{}), map[string]string{
+				"Index": "SG_FAC_SOLAR", "Race": "1", "Size": "2", "Product": "SS_WARE_ENERGY", "ObjectID": "SS_FACTORY_SOLAR"}),
+		"addon/types/TGates.txt": "22;2;\n" +
+			testLine(reflect.TypeOf(TGate{}), map[string]string{"Index": "SG_GATE_NORTH", "ObjectID": "SS_GATE_N"}) +
+			testLine(reflect.TypeOf(TGate{}), map[string]string{"Index": "SG_GATE_EAST", "Type": "3", "ObjectID": "SS_GATE_E"}),
+	})
+	m := x.GetMissiles()
+	if len(m) != 1 {
+		t.Fatalf("got %v", m)
+	}
+	if m[0].Description != "Dragonfly" || m[0].Speed != 500 || m[0].Lifetime != 8000 || m[0].Damage != 1200 || m[0].ObjectID != "SS_WARE_MISSILE_DRAGONFLY" {
+		t.Errorf("bad missile: %+v", m[0])
+	}
+	w, err := x.GetWares("E")
+	if err != nil || len(w) != 1 || w[0].Description != "Energy Cells" || w[0].RotY != 0.5 || w[0].ObjectID != "SS_WARE_ENERGY" {
+		t.Errorf("bad ware: %+v %v", w, err)
+	}
+	if w, err := x.GetWares("X"); err == nil {
+		t.Errorf("unknown ware class: %v", w)
+	}
+	f := x.GetFactories()
+	if len(f) != 1 || f[0].Race != 1 || f[0].Size != 2 || f[0].Product != "SS_WARE_ENERGY" || f[0].ObjectID != "SS_FACTORY_SOLAR" {
+		t.Errorf("bad factory: %+v", f)
+	}
+	g := x.GetGates()
+	if len(g) != 2 || g[0].Type != 0 || g[1].Type != 3 || g[1].Index != "SG_GATE_EAST" || g[1].ObjectID != "SS_GATE_E" {
+		t.Errorf("bad gates: %+v", g)
+	}
+	if p := parseErrors(x); len(p) != 0 {
+		t.Errorf("problems: %v", p)
+	}
+}
+
+func TestWriteType(t *testing.T) {
+	lasers := "// lasers\n" +
+		"22;2;\n" +
+		"b\\l;0;0;1.50;0;SS_LASER;100;200;7;1;10;0.5;h;1;2;3;4;5;6;7;8;9;SS_LASER_A; // first\n" +
+		"\n" +
+		"// second\n" +
+		"b\\l;0;0;0;0;SS_LASER;0;200;7;-1;10;0.5;h;1;2;3;4;5;6;7;8;9;SS_LASER_B;\n" +
+		"// end\n"
+	bullets := "22;3;\n" +
+		"b\\b;0;0;0;0;SS_BULLET;0\n" +
+		"// deleted below\n" +
+		"b\\b;0;0;0;0;SS_BULLET;101\n" +
+		"b\\b;0;2.50;0;0;SS_BULLET;0\n"
+	x := testX(t, map[string]string{
+		"addon/t/0001-L044.xml":    testText,
+		"addon/types/TLaser.txt":   lasers,
+		"addon/types/TBullets.txt": bullets,
+	})
+	for typ, want := range map[string]string{"Lasers": lasers, "Bullets": bullets} {
+		var b strings.Builder
+		if err := x.WriteType(&b, typ, x.getType(typ).v); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != want {
+			t.Errorf("%s round trip:\n%s\nwant:\n%s", typ, b.String(), want)
+		}
+	}
+
+	l := append(x.GetLasers(), x.GetLasers()[0])
+	l[0].Description = "Energy Cells"
+	l[0].RotX = 0.25
+	l[0].Projectile = nil
+	l[1].Projectile = &x.getType("Bullets").v.([]TBullet)[0]
+	l[2].ObjectID = "SS_LASER_C"
+	var b strings.Builder
+	if err := x.WriteType(&b, "Lasers", l); err != nil {
+		t.Fatal(err)
+	}
+	want := "// lasers\n" +
+		"22;3;\n" +
+		"b\\l;0;0.25;1.50;0;SS_LASER;101;200;7;-1;10;0.5;h;1;2;3;4;5;6;7;8;9;SS_LASER_A; // first\n" +
+		"\n" +
+		"// second\n" +
+		"b\\l;0;0;0;0;SS_LASER;0;200;7;0;10;0.5;h;1;2;3;4;5;6;7;8;9;SS_LASER_B;\n" +
+		"b\\l;0;0;1.50;0;SS_LASER;100;200;7;1;10;0.5;h;1;2;3;4;5;6;7;8;9;SS_LASER_C; // first\n" +
+		"// end\n"
+	if b.String() != want {
+		t.Errorf("edited:\n%s\nwant:\n%s", b.String(), want)
+	}
+
+	// Records keep what they looked like in the file when others are
+	// deleted from the middle.
+	bl := append([]TBullet{}, x.getType("Bullets").v.([]TBullet)...)
+	bl = append(bl[:1], bl[2:]...)
+	b.Reset()
+	if err := x.WriteType(&b, "Bullets", bl); err != nil {
+		t.Fatal(err)
+	}
+	if want := "22;2;\n" +
+		"b\\b;0;0;0;0;SS_BULLET;0\n" +
+		"b\\b;0;2.50;0;0;SS_BULLET;0\n"; b.String() != want {
+		t.Errorf("deleted:\n%s\nwant:\n%s", b.String(), want)
+	}
+
+	l[0].Description = "Nothing"
+	if err := x.WriteType(&b, "Lasers", l); err == nil {
+		t.Errorf("wrote text that doesn't exist")
+	}
+}
+
+func TestRawToken(t *testing.T) {
+	x := testX(t, map[string]string{
+		"addon/t/0001-L044.xml": testText,
+		"addon/types/TLaser.txt": "22;1;\n" +
+			"b\\l;0;0;0;0;SS_LASER;100;200;7;1;10;0.5;h;1;2;3;4;5;6;7;8;9;SS_LASER_A;\n",
+		"addon/types/TBullets.txt": "22;2;\n" +
+			"b\\b;0;0;0;0;SS_BULLET;0\n" +
+			"b\\b;0;0;0;0;SS_BULLET;101\n",
+	})
+	l := &x.GetLasers()[0]
+	if s, ok := x.RawToken(l, "Projectile"); !ok || s != "1" {
+		t.Errorf("Projectile: %q %v", s, ok)
+	}
+	if p, id, ok := x.TextID(l, "Description"); !ok || p != 17 || id != 100 {
+		t.Errorf("Description: %d %d %v", p, id, ok)
+	}
+	if _, _, ok := x.TextID(l.Projectile, "Description"); !ok {
+		t.Errorf("Projectile description not found")
+	}
+	if _, _, ok := x.TextID(&x.getType("Bullets").v.([]TBullet)[0], "Description"); ok {
+		t.Errorf("empty description has an id")
+	}
+	if _, ok := x.RawToken(&TLaser{}, "Description"); ok {
+		t.Errorf("found a record that isn't ours")
+	}
+}
+
+// Problems without the files that don't exist.
+func parseErrors(x *X) []error {
+	ret := []error{}
+	for _, err := range x.Problems() {
+		if !errors.Is(err, ErrNotFound) {
+			ret = append(ret, err)
+		}
+	}
+	return ret
+}
+
+func TestParseErrors(t *testing.T) {
+	files := map[string]string{
+		"addon/t/0001-L044.xml": testText,
+		"addon/types/TLaser.txt": "22;3;\n" +
+			"b\\l;0;0;0;0;SG_LASER_IRE;100;200;7;-1;10;0.5;h;1;2;3;4;5;6;7;8;9;SS_LASER_A;\n" +
+			"// comment\n" +
+			"b\\l;0;0;0;0;SG_LASER_IRE;100;x;7;-1;10;0.5;h;1;2;3;4;5;6;7;8;9;SS_LASER_B;\n" +
+			"b\\l;0;0;0;0;SG_LASER_IRE;100;200;7;-1;10;0.5;h;1;2;3;4;5;6;7;8;9;SS_LASER_C;extra;\n",
+	}
+	x := testX(t, files)
+	if l := x.GetLasers(); len(l) != 1 || l[0].ObjectID != "SS_LASER_A" {
+		t.Errorf("strict parse didn't keep the good records: %v", l)
+	}
+	p := parseErrors(x)
+	var pe *ParseError
+	if len(p) != 1 || !errors.As(p[0], &pe) {
+		t.Fatalf("problems: %v", p)
+	}
+	if pe.File != "addon/types/TLaser.txt" || pe.Line != 4 || pe.Record != 1 || pe.Field != "RoF" || pe.Token != "x" {
+		t.Errorf("bad error: %#v", pe)
+	}
+
+	inst := t.TempDir()
+	writeFiles(t, inst, files)
+	x, err := NewXOptions(inst, Options{Lenient: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := x.GetLasers()
+	if len(l) != 3 || l[0].ObjectID != "SS_LASER_A" || l[1].ObjectID != "" || l[2].ObjectID != "" {
+		t.Errorf("lenient: %v", l)
+	}
+	if _, ok := x.getType("Lasers").byid[""]; ok || len(x.getType("Lasers").byid) != 1 {
+		t.Errorf("bad records can be found by ObjectID: %v", x.getType("Lasers").byid)
+	}
+	p = parseErrors(x)
+	if len(p) != 2 || !errors.As(p[1], &pe) || !errors.Is(pe, errNotConsumed) || pe.Record != 2 || pe.Line != 5 {
+		t.Errorf("lenient problems: %v", p)
+	}
+
+	// Missing files are only a problem for the types we need.
+	missing := map[string]bool{}
+	for _, err := range x.Problems() {
+		var fe *FileError
+		if errors.As(err, &fe) && errors.Is(err, ErrNotFound) {
+			missing[fe.Path] = true
+		}
+	}
+	if !missing["addon/types/TShips.txt"] || missing["addon/types/TAsteroids.txt"] {
+		t.Errorf("missing: %v", missing)
+	}
+}
+
+// A line for a record type, "0" for everything not in vals (a map
+// of field paths) and "-1" for pointers. The line stops before the
+// first slice.
+func testLine(t reflect.Type, vals map[string]string) string {
+	toks := []string{}
+	var walk func(prefix string, t reflect.Type) bool
+	walk = func(prefix string, t reflect.Type) bool {
+		switch t.Kind() {
+		case reflect.Struct:
+			for i := 0; i < t.NumField(); i++ {
+				if t.Field(i).Type == rawRefType {
+					continue
+				}
+				if !walk(fieldPath(prefix, t.Field(i)), t.Field(i).Type) {
+					return false
+				}
+			}
+		case reflect.Array:
+			for i := 0; i < t.Len(); i++ {
+				if !walk(fmt.Sprintf("%s[%d]", prefix, i), t.Elem()) {
+					return false
+				}
+			}
+		case reflect.Slice:
+			return false
+		default:
+			v, ok := vals[prefix]
+			if !ok {
+				v = "0"
+				if t.Kind() == reflect.Ptr {
+					v = "-1"
+				}
+			}
+			toks = append(toks, v)
+		}
+		return true
+	}
+	if walk("", t) {
+		toks = append(toks, "")
+	}
+	return strings.Join(toks, ";") + "\n"
+}
+
+func TestObjectIDRef(t *testing.T) {
+	bt := reflect.TypeOf(TBullet{})
+	bullets := "22;3;\n" +
+		testLine(bt, map[string]string{"Ammo": "SS_WARE_AMMO", "ObjectID": "SS_BULLET_A"}) +
+		testLine(bt, map[string]string{"Ammo": "SS_WARE_CELLS", "ObjectID": "SS_BULLET_B"}) +
+		testLine(bt, map[string]string{"Ammo": "128", "ObjectID": "SS_BULLET_C"})
+	x := testX(t, map[string]string{
+		"addon/t/0001-L044.xml": testText,
+		"addon/types/TWareT.txt": "22;1;\n" +
+			"b;0;0;0;0;SG_WARE;0;1;2;3;4;0;6;7;8;9;SS_WARE_AMMO;\n",
+		"addon/types/TWareE.txt": "22;1;\n" +
+			"b;0;0;0;0;SG_WARE;101;1;2;3;4;0;6;7;8;9;SS_WARE_CELLS;\n",
+		"addon/types/TBullets.txt": bullets,
+	})
+	b := x.getType("Bullets").v.([]TBullet)
+	wt, _ := x.GetWares("T")
+	we, _ := x.GetWares("E")
+	if len(b) != 3 || b[0].Ammo != &wt[0] || b[1].Ammo != &we[0] || b[2].Ammo != nil {
+		t.Fatalf("bad ammo: %v", b)
+	}
+	if s, _ := x.RawToken(&b[2], "Ammo"); s != "128" {
+		t.Errorf("raw token of unknown ware: %q", s)
+	}
+	var pe *ParseError
+	if p := parseErrors(x); len(p) != 1 || !errors.As(p[0], &pe) || pe.Record != 2 || pe.Line != 4 || pe.Field != "Ammo" || pe.Token != "128" || !errors.Is(pe, errUnresolved) {
+		t.Errorf("problems: %v", p)
+	}
+	var w strings.Builder
+	if err := x.WriteType(&w, "Bullets", b); err != nil || w.String() != bullets {
+		t.Errorf("round trip: %v\n%s", err, w.String())
+	}
+	b2 := append([]TBullet{}, b...)
+	b2[2].Ammo = b2[1].Ammo
+	w.Reset()
+	if err := x.WriteType(&w, "Bullets", b2); err != nil || !strings.Contains(w.String(), ";SS_WARE_CELLS;0;0;0;0;0;0;0;0;SS_BULLET_C;") {
+		t.Errorf("changed ammo: %v\n%s", err, w.String())
+	}
+}
+
+func TestSceneFile(t *testing.T) {
+	var pb bytes.Buffer
+	if err := WritePck(&pb, strings.NewReader("// dock\nP 0; B stations\\dock_body; N dock;\nP 1; B dockport_ts;\n"), PckXOR, 3); err != nil {
+		t.Fatal(err)
+	}
+	x := testX(t, map[string]string{
+		"addon/t/0001-L044.xml": testText,
+		"addon/types/TDocks.txt": "22;1;\n" +
+			testLine(reflect.TypeOf(TDock{}), map[string]string{"SceneFile": "stations\\docks\\dock1", "ObjectID": "SS_DOCK_1"}),
+		"addon/types/TCockpits.txt": "22;1;\n" +
+			testLine(reflect.TypeOf(Cockpit{}), map[string]string{"SceneFile": "ships\\cockpits\\turret", "ObjectID": "SS_COCKPIT_1"}),
+		"objects/stations/docks/dock1.pbd":  pb.String(),
+		"objects/ships/cockpits/turret.bod": "P 0; B turret_body;\n",
+	})
+	if sc := x.SceneFile(x.DockByID("SS_DOCK_1").SceneFile); sc == nil || len(sc.P) != 2 || sc.P[0].B != "stations\\dock_body" || sc.P[1].B != "dockport_ts" {
+		t.Errorf("dock scene: %+v", sc)
+	}
+	if sc := x.SceneFile(x.getType("Cockpits").v.([]Cockpit)[0].SceneFile); sc == nil || len(sc.P) != 1 || sc.P[0].B != "turret_body" {
+		t.Errorf("cockpit scene: %+v", sc)
+	}
+	if sc := x.SceneFile("nope"); sc != nil {
+		t.Errorf("missing scene: %+v", sc)
+	}
+}
@@ -0,0 +1,267 @@
+package xt
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Writing types/*.txt files back from the structures tparse fills in.
+
+type tWriter struct {
+	x       *X
+	t       Text
+	raw     *rawRecord // nil for records that weren't in the file.
+	path    string
+	lastTag string
+	out     []string
+	present []bool // If the value was in the file or is new. Trailing values that weren't are dropped.
+}
+
+// WriteType writes the records of type typ (a key in typeMap like
+// "Ships") in the format of types/*.txt. slice must be a slice of the
+// right type, usually what GetShips and friends returned, edited.
+//
+// Values that haven't changed are written exactly as they were in the
+// file, records remember what they looked like so they can be deleted,
+// inserted and reordered. Text (page tags) is written as the id of a string with that
+// text and references (tref tags) as the index of what they point to,
+// so the pointers have to point into the slices we handed out. Comment
+// and empty lines stay with the record below them, the ones at the
+// start and end of the file stay there.
+func (x *X) WriteType(w io.Writer, typ string, slice interface{}) error {
+	tm, ok := typeMap[typ]
+	if !ok {
+		return fmt.Errorf("unknown type %s", typ)
+	}
+	sv := reflect.ValueOf(slice)
+	if sv.Kind() != reflect.Slice || sv.Type().Elem() != tm.t {
+		return fmt.Errorf("%s wants []%v, got %T", typ, tm.t, slice)
+	}
+	tc := x.getType(typ)
+	if tc.file == nil {
+		return fmt.Errorf("don't know the version of %s", tm.fn)
+	}
+	bw := bufio.NewWriter(w)
+	writeLines(bw, tc.file.head)
+	fmt.Fprintf(bw, "%s;%d;\n", tc.file.ver, sv.Len())
+	for i := 0; i < sv.Len(); i++ {
+		tw := tWriter{x: x, t: x.GetText(), raw: rawOf(sv.Index(i))}
+		if err := tw.wvalue(sv.Index(i)); err != nil {
+			return fmt.Errorf("%s record %d: %v", typ, i, err)
+		}
+		line, err := tw.line()
+		if err != nil {
+			return fmt.Errorf("%s record %d: %v", typ, i, err)
+		}
+		if tw.raw != nil {
+			writeLines(bw, tw.raw.before)
+		}
+		bw.WriteString(line + "\n")
+	}
+	writeLines(bw, tc.file.tail)
+	return bw.Flush()
+}
+
+func writeLines(w *bufio.Writer, lines []string) {
+	for _, l := range lines {
+		w.WriteString(l + "\n")
+	}
+}
+
+func (tw *tWriter) line() (string, error) {
+	n := len(tw.out)
+	for n > 0 && !tw.present[n-1] {
+		n--
+	}
+	toks := tw.out[:n]
+	if tw.raw != nil {
+		toks = append(toks, tw.raw.rest...)
+	} else {
+		toks = append(toks, "")
+	}
+	for _, s := range toks {
+		if strings.ContainsAny(s, ";\r\n") {
+			return "", fmt.Errorf("can't write value %q", s)
+		}
+	}
+	return strings.Join(toks, ";"), nil
+}
+
+func (tw *tWriter) emit(s string, present bool) {
+	tw.out = append(tw.out, s)
+	tw.present = append(tw.present, present)
+}
+
+// Write a single value. If the value in the file still parses to
+// the same thing, write that, otherwise encode it with enc.
+func (tw *tWriter) leaf(v reflect.Value, enc func() (string, error)) error {
+	if tw.raw != nil {
+		if s, ok := tw.raw.tok[tw.path]; ok {
+			if tw.same(v, s) {
+				tw.emit(s, true)
+				return nil
+			}
+		} else if v.IsZero() {
+			s, err := enc()
+			if err != nil {
+				return fmt.Errorf("%s: %v", tw.path, err)
+			}
+			tw.emit(s, false)
+			return nil
+		}
+	}
+	s, err := enc()
+	if err != nil {
+		return fmt.Errorf("%s: %v", tw.path, err)
+	}
+	tw.emit(s, true)
+	return nil
+}
+
+func (tw *tWriter) same(v reflect.Value, s string) bool {
+	t := tParser{rec: []string{s}, lastTag: tw.lastTag, t: tw.t, x: tw.x}
+	nv := reflect.New(v.Type()).Elem()
+	if t.pvalue(nv) != nil {
+		return false
+	}
+	if v.Kind() == reflect.Ptr {
+		return nv.Pointer() == v.Pointer()
+	}
+	return reflect.DeepEqual(nv.Interface(), v.Interface())
+}
+
+func (tw *tWriter) wvalue(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Int:
+		return tw.leaf(v, func() (string, error) { return strconv.FormatInt(v.Int(), 10), nil })
+	case reflect.Uint:
+		// Signed, so that all bits set is written as -1 like the game does.
+		return tw.leaf(v, func() (string, error) { return strconv.FormatInt(int64(int32(v.Uint())), 10), nil })
+	case reflect.Float64:
+		return tw.leaf(v, func() (string, error) { return strconv.FormatFloat(v.Float(), 'f', -1, 64), nil })
+	case reflect.String:
+		return tw.leaf(v, func() (string, error) { return tw.wstring(v.String()) })
+	case reflect.Array:
+		return tw.warray(v)
+	case reflect.Struct:
+		return tw.wstruct(v)
+	case reflect.Slice:
+		return tw.wslice(v)
+	case reflect.Ptr:
+		return tw.leaf(v, func() (string, error) { return tw.wptr(v) })
+	default:
+		return fmt.Errorf("bad kind: %v", v.Kind())
+	}
+}
+
+// The reverse of pstring, find the id of the string.
+func (tw *tWriter) wstring(s string) (string, error) {
+	tags := tagParse(tw.lastTag)
+	if tags["page"] == "" {
+		return s, nil
+	}
+	if s == "" {
+		return "0", nil
+	}
+	pid, err := strconv.Atoi(tags["page"])
+	if err != nil {
+		return "", fmt.Errorf("Bad page tag: %v", tw.lastTag)
+	}
+	var off int
+	if tags["offset"] != "" {
+		off, err = strconv.Atoi(tags["offset"])
+		if err != nil {
+			return "", fmt.Errorf("Bad offset tag: %v", tags["offset"])
+		}
+	}
+	ids := make([]int, 0, len(tw.t[pid]))
+	for tid := range tw.t[pid] {
+		ids = append(ids, tid)
+	}
+	sort.Ints(ids)
+	for _, tid := range ids {
+		if ts, err := tw.t.Get(pid, tid); err == nil && ts == s {
+			return strconv.Itoa(tid - off), nil
+		}
+	}
+	return "", fmt.Errorf("no text %q on page %d", s, pid)
+}
+
+// The reverse of pptr, find the index or ObjectID of what we point to.
+func (tw *tWriter) wptr(v reflect.Value) (string, error) {
+	tags := tagParse(tw.lastTag)
+	ref := tags["tref"]
+	if ref == "" {
+		return "", fmt.Errorf("Don't know how to handle tag: %s", tw.lastTag)
+	}
+	if v.IsNil() {
+		return "-1", nil
+	}
+	if tags["index"] != "true" {
+		id := v.Elem().FieldByName("ObjectID")
+		if !id.IsValid() {
+			return "", fmt.Errorf("%v doesn't have an ObjectID", v.Type())
+		}
+		return id.String(), nil
+	}
+	tv := reflect.ValueOf(tw.x.getType(ref).v)
+	for i := 0; i < tv.Len(); i++ {
+		if tv.Index(i).Addr().Pointer() == v.Pointer() {
+			return strconv.Itoa(i), nil
+		}
+	}
+	return "", fmt.Errorf("doesn't point into %s", ref)
+}
+
+func (tw *tWriter) warray(v reflect.Value) error {
+	prefix := tw.path
+	defer func() { tw.path = prefix }()
+	for i := 0; i < v.Len(); i++ {
+		tw.path = fmt.Sprintf("%s[%d]", prefix, i)
+		if err := tw.wvalue(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tw *tWriter) wstruct(v reflect.Value) error {
+	prefix := tw.path
+	defer func() { tw.path = prefix }()
+	for i := 0; i < v.NumField(); i++ {
+		sf := v.Type().Field(i)
+		if sf.Type == rawRefType {
+			continue
+		}
+		tw.lastTag = sf.Tag.Get("x3t")
+		tw.path = fieldPath(prefix, sf)
+		if err := tw.wvalue(v.Field(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Slices are prefixed with a length.
+func (tw *tWriter) wslice(v reflect.Value) error {
+	present := v.Len() != 0 || tw.raw == nil
+	if tw.raw != nil {
+		_, ok := tw.raw.tok[tw.path]
+		present = present || ok
+	}
+	tw.emit(strconv.Itoa(v.Len()), present)
+	prefix := tw.path
+	defer func() { tw.path = prefix }()
+	for i := 0; i < v.Len(); i++ {
+		tw.path = fmt.Sprintf("%s[%d]", prefix, i)
+		if err := tw.wvalue(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package xt
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Comparing the records in types/ between two installations.
+
+// Which types we compare.
+var diffTypes = []string{"Ships", "Lasers", "Shields"}
+
+type FieldChange struct {
+	Field string // For example "GunGroup[2].Gun[0].BodyID"
+	A, B  string // Empty if the field doesn't exist on that side.
+}
+
+type RecordDiff struct {
+	Type     string // Key in typeMap, "Ships", "Lasers", etc.
+	ObjectID string
+	Name     string
+	Change   DiffChange
+	Fields   []FieldChange
+}
+
+func (d RecordDiff) String() string {
+	if d.Change != DiffChanged {
+		return fmt.Sprintf("%s: %s", d.Name, d.Change)
+	}
+	f := make([]string, len(d.Fields))
+	for i, fc := range d.Fields {
+		f[i] = fmt.Sprintf("%s %s→%s", fc.Field, fc.A, fc.B)
+	}
+	return fmt.Sprintf("%s: %s", d.Name, strings.Join(f, ", "))
+}
+
+// DiffTypes compares ships, lasers and shields between two
+// installations. Records are matched by ObjectID, if there are
+// multiple records with the same ObjectID they are matched in order.
+func DiffTypes(a, b *X) []RecordDiff {
+	ret := []RecordDiff{}
+	for _, t := range diffTypes {
+		ret = append(ret, diffType(t, a.getType(t).v, b.getType(t).v)...)
+	}
+	return ret
+}
+
+func diffType(t string, a, b interface{}) []RecordDiff {
+	av, bv := reflect.ValueOf(a), reflect.ValueOf(b)
+	bids := make(map[string][]int)
+	for i := 0; i < bv.Len(); i++ {
+		id := recordID(bv.Index(i))
+		bids[id] = append(bids[id], i)
+	}
+	ret := []RecordDiff{}
+	for i := 0; i < av.Len(); i++ {
+		ar := av.Index(i)
+		id := recordID(ar)
+		d := RecordDiff{Type: t, ObjectID: id, Name: recordName(ar)}
+		if len(bids[id]) == 0 {
+			d.Change = DiffRemoved
+			ret = append(ret, d)
+			continue
+		}
+		br := bv.Index(bids[id][0])
+		bids[id] = bids[id][1:]
+		d.Fields = diffFields(ar, br)
+		if len(d.Fields) != 0 {
+			d.Change = DiffChanged
+			ret = append(ret, d)
+		}
+	}
+	// Whatever is left wasn't in a.
+	for i := 0; i < bv.Len(); i++ {
+		br := bv.Index(i)
+		id := recordID(br)
+		if len(bids[id]) != 0 && bids[id][0] == i {
+			bids[id] = bids[id][1:]
+			ret = append(ret, RecordDiff{Type: t, ObjectID: id, Name: recordName(br), Change: DiffAdded})
+		}
+	}
+	return ret
+}
+
+func recordID(v reflect.Value) string {
+	if f := v.FieldByName("ObjectID"); f.IsValid() {
+		return f.String()
+	}
+	return ""
+}
+
+// Something a human can recognize.
+func recordName(v reflect.Value) string {
+	n := []string{}
+	for _, fn := range []string{"Description", "Variation"} {
+		if f := v.FieldByName(fn); f.IsValid() && f.String() != "" {
+			n = append(n, f.String())
+		}
+	}
+	if len(n) == 0 {
+		return recordID(v)
+	}
+	return strings.Join(n, " ")
+}
+
+type fieldVal struct {
+	path, val string
+}
+
+func diffFields(a, b reflect.Value) []FieldChange {
+	var af, bf []fieldVal
+	flatten("", a, &af)
+	flatten("", b, &bf)
+	bm := make(map[string]string, len(bf))
+	for _, f := range bf {
+		bm[f.path] = f.val
+	}
+	ret := []FieldChange{}
+	seen := make(map[string]bool, len(af))
+	for _, f := range af {
+		seen[f.path] = true
+		if bval, ok := bm[f.path]; !ok || bval != f.val {
+			ret = append(ret, FieldChange{f.path, f.val, bval})
+		}
+	}
+	for _, f := range bf {
+		if !seen[f.path] {
+			ret = append(ret, FieldChange{f.path, "", f.val})
+		}
+	}
+	return ret
+}
+
+// Turn a record into a list of paths and values.
+func flatten(prefix string, v reflect.Value, out *[]fieldVal) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Uint:
+		*out = append(*out, fieldVal{prefix, fmt.Sprint(v.Interface())})
+	case reflect.Float64:
+		*out = append(*out, fieldVal{prefix, strconv.FormatFloat(v.Float(), 'g', -1, 64)})
+	case reflect.String:
+		*out = append(*out, fieldVal{prefix, v.String()})
+	case reflect.Array, reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			flatten(fmt.Sprintf("%s[%d]", prefix, i), v.Index(i), out)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if sf := v.Type().Field(i); sf.Type != rawRefType {
+				flatten(fieldPath(prefix, sf), v.Field(i), out)
+			}
+		}
+	case reflect.Ptr:
+		// References to other records, compare what they point to by name.
+		if v.IsNil() {
+			*out = append(*out, fieldVal{prefix, "-"})
+		} else {
+			*out = append(*out, fieldVal{prefix, recordName(v.Elem())})
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package xt
+
+import (
+	"testing"
+)
+
+func TestDiffType(t *testing.T) {
+	sh := []TShield{{Description: "1 GJ Shield", ObjectID: "SS_SH_1"}}
+	a := []Ship{
+		{Description: "Nova", Variation: "Raider", HullStrength: 9000, MaxShieldCount: 3, ShieldType: &sh[0], ObjectID: "SS_SH_A_M3_R"},
+		{Description: "Gone", ObjectID: "SS_GONE"},
+	}
+	b := []Ship{
+		{Description: "Nova", Variation: "Raider", HullStrength: 12000, MaxShieldCount: 4, ObjectID: "SS_SH_A_M3_R"},
+		{Description: "New", ObjectID: "SS_NEW"},
+	}
+	d := diffType("Ships", a, b)
+	if len(d) != 3 {
+		t.Fatalf("got %v", d)
+	}
+	if s := d[0].String(); s != "Nova Raider: ShieldType 1 GJ Shield→-, MaxShieldCount 3→4, HullStrength 9000→12000" {
+		t.Errorf("got %s", s)
+	}
+	if d[1].Change != DiffRemoved || d[1].ObjectID != "SS_GONE" {
+		t.Errorf("got %v", d[1])
+	}
+	if d[2].Change != DiffAdded || d[2].ObjectID != "SS_NEW" {
+		t.Errorf("got %v", d[2])
+	}
+}
@@ -1,9 +1,13 @@
 package xt
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"reflect"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 )
 
@@ -11,6 +15,8 @@ type typeCache struct {
 	once sync.Once
 	v    interface{}
 	byid map[string]interface{}
+	file *rawFile // For writing it back, nil if we don't have all the records.
+	errs []error
 }
 
 var typeMap = map[string]struct {
@@ -25,21 +31,63 @@ var typeMap = map[string]struct {
 	"Docks":         {"addon/types/TDocks.txt", reflect.TypeOf(TDock{})},
 	"Bullets":       {"addon/types/TBullets.txt", reflect.TypeOf(TBullet{})},
 	"DummyAnimated": {"addon/types/Dummies.txt", reflect.TypeOf(DummyAnimated{})},
+	"Missiles":      {"addon/types/TMissiles.txt", reflect.TypeOf(TMissile{})},
+	"Factories":     {"addon/types/TFactories.txt", reflect.TypeOf(TFactory{})},
+	"WaresB":        {"addon/types/TWareB.txt", reflect.TypeOf(TWare{})},
+	"WaresE":        {"addon/types/TWareE.txt", reflect.TypeOf(TWare{})},
+	"WaresF":        {"addon/types/TWareF.txt", reflect.TypeOf(TWare{})},
+	"WaresM":        {"addon/types/TWareM.txt", reflect.TypeOf(TWare{})},
+	"WaresN":        {"addon/types/TWareN.txt", reflect.TypeOf(TWare{})},
+	"WaresT":        {"addon/types/TWareT.txt", reflect.TypeOf(TWare{})},
+	"Specials":      {"addon/types/TSpecial.txt", reflect.TypeOf(TSpecial{})},
+	"Gates":         {"addon/types/TGates.txt", reflect.TypeOf(TGate{})},
+	"Planets":       {"addon/types/TPlanets.txt", reflect.TypeOf(TPlanet{})},
+	"Backgrounds":   {"addon/types/TBackgrounds.txt", reflect.TypeOf(TBackground{})},
+	"Asteroids":     {"addon/types/TAsteroids.txt", reflect.TypeOf(TAsteroid{})},
 }
 
+// Find what a tref tag points to. With index the value is an index
+// into typ, otherwise it's an ObjectID in any of the types separated
+// by "|", for example "WaresE|WaresT". A zero Value means that it
+// points to nothing, this includes ObjectIDs we don't know about.
 func (x *X) typeLookup(typ string, value string, index bool) (reflect.Value, error) {
-	t := x.getType(typ).v
 	if index {
+		if _, ok := typeMap[typ]; !ok {
+			return reflect.Value{}, fmt.Errorf("unknown type %s", typ)
+		}
 		i, err := strconv.Atoi(value)
 		if err != nil {
 			return reflect.Value{}, err
 		}
+		tv := reflect.ValueOf(x.getType(typ).v)
 		if i < 0 {
-			return reflect.Zero(reflect.ValueOf(t).Index(0).Addr().Type()), nil
+			return reflect.Value{}, nil
+		}
+		if i >= tv.Len() {
+			return reflect.Value{}, fmt.Errorf("%s index out of range: %d", typ, i)
+		}
+		return tv.Index(i).Addr(), nil
+	}
+	for _, t := range strings.Split(typ, "|") {
+		if _, ok := typeMap[t]; !ok {
+			return reflect.Value{}, fmt.Errorf("unknown type %s", t)
+		}
+		if p, ok := x.getType(t).byid[value]; ok {
+			return reflect.ValueOf(p), nil
 		}
-		return reflect.ValueOf(t).Index(i).Addr(), nil
 	}
-	return reflect.Value{}, fmt.Errorf("not implemented")
+	return reflect.Value{}, nil
+}
+
+// The types x3t isn't much use without. The others are allowed to be
+// missing, for those it's not a problem.
+var requiredTypes = map[string]bool{
+	"Ships":    true,
+	"Cockpits": true,
+	"Lasers":   true,
+	"Shields":  true,
+	"Bullets":  true,
+	"Missiles": true,
 }
 
 func (x *X) getType(t string) *typeCache {
@@ -49,27 +97,140 @@ func (x *X) getType(t string) *typeCache {
 		if hasID {
 			tc.byid = make(map[string]interface{})
 		}
-		f := x.xf.Open(typeMap[t].fn)
-		defer f.Close()
 		v := reflect.Indirect(reflect.New(reflect.SliceOf(typeMap[t].t)))
-		x.tparsev(f, v)
+		tc.v = v.Interface()
+		f, err := x.xf.Open(typeMap[t].fn)
+		if err != nil {
+			if requiredTypes[t] || !errors.Is(err, ErrNotFound) {
+				log.Print(err)
+				tc.errs = []error{err}
+			}
+			return
+		}
+		defer f.Close()
+		var unres []error
+		tc.file, tc.errs, unres = x.tparsev(typeMap[t].fn, f, v, x.lenient)
+		if len(tc.errs) != 0 && !x.lenient {
+			log.Print(tc.errs[0])
+			// Only some of the records, writing them back would
+			// lose the rest.
+			tc.file = nil
+		}
+		tc.errs = append(tc.errs, unres...)
 		tc.v = v.Interface()
 		if hasID {
 			for i := 0; i < v.Len(); i++ {
 				elem := v.Index(i)
-				tc.byid[elem.FieldByIndex(idField.Index).String()] = elem.Addr().Interface()
+				// Records that didn't parse are zeroed.
+				if id := elem.FieldByIndex(idField.Index).String(); id != "" {
+					tc.byid[id] = elem.Addr().Interface()
+				}
 			}
 		}
 	})
 	return tc
 }
 
+// Problems returns everything that went wrong when parsing types/,
+// including required types that are missing, ObjectIDs that point to
+// nothing and subtypes that don't
+// fit in the laser and missile masks. This loads all the types.
+func (x *X) Problems() []error {
+	names := make([]string, 0, len(typeMap))
+	for k := range typeMap {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	ret := []error{}
+	for _, n := range names {
+		ret = append(ret, x.getType(n).errs...)
+	}
+	ret = append(ret, x.laserTable().errs...)
+	ret = append(ret, x.missileTable().errs...)
+	return ret
+}
+
+// What rec, a pointer to a record, looked like in the file.
+func (x *X) findRaw(rec interface{}) *rawRecord {
+	if h, ok := rec.(hasRaw); ok && !reflect.ValueOf(rec).IsNil() {
+		return h.fileRaw()
+	}
+	return nil
+}
+
+// The x3t tag of field, a path like "TurretDescriptor[2].Cockpit".
+// Array and slice elements have the tag of the field they are in.
+func fieldTag(t reflect.Type, path string) (string, bool) {
+	tag := ""
+	for _, seg := range strings.Split(path, ".") {
+		name := seg
+		if i := strings.IndexByte(seg, '['); i >= 0 {
+			name = seg[:i]
+		}
+		if t.Kind() != reflect.Struct {
+			return "", false
+		}
+		sf, ok := t.FieldByName(name)
+		if !ok {
+			return "", false
+		}
+		tag, t = sf.Tag.Get("x3t"), sf.Type
+		for i := strings.Count(seg, "["); i > 0; i-- {
+			if t.Kind() != reflect.Array && t.Kind() != reflect.Slice {
+				return "", false
+			}
+			t = t.Elem()
+		}
+	}
+	return tag, true
+}
+
+// RawToken returns the value of a field the way it was in the file,
+// before text ids were looked up and indexes turned into pointers.
+// rec is a pointer to a record from one of the Get functions (GetShips,
+// GetLasers, etc.) and field is a path like "Description",
+// "ShieldType" or "TurretDescriptor[2].Cockpit".
+func (x *X) RawToken(rec interface{}, field string) (string, bool) {
+	raw := x.findRaw(rec)
+	if raw == nil {
+		return "", false
+	}
+	s, ok := raw.tok[field]
+	return s, ok
+}
+
+// TextID returns the page and id of the text that a field with a page
+// tag was looked up from. ok is false if the field isn't text or it
+// didn't have any text.
+func (x *X) TextID(rec interface{}, field string) (page, id int, ok bool) {
+	s, found := x.RawToken(rec, field)
+	if !found {
+		return 0, 0, false
+	}
+	tag, _ := fieldTag(reflect.TypeOf(rec).Elem(), field)
+	tags := tagParse(tag)
+	if tags["page"] == "" || s == "0" || s == tags["ignore"] {
+		return 0, 0, false
+	}
+	page, err := strconv.Atoi(tags["page"])
+	if err != nil {
+		return 0, 0, false
+	}
+	id, err = strconv.Atoi(s)
+	if err != nil {
+		return 0, 0, false
+	}
+	off, _ := strconv.Atoi(tags["offset"])
+	return page, id + off, true
+}
+
 func (x *X) GetSuns() []TSun {
 	return x.getType("Suns").v.([]TSun)
 }
 
 // the only documentation of this I found was wrong.
 type TSun struct {
+	rawRef
 	Unknown01  int
 	Unknown02  int
 	Unknown03  int
@@ -96,6 +257,7 @@ func (x *X) GetShips() []Ship {
 }
 
 type Ship struct {
+	rawRef
 	BodyFile  string
 	PictureID string
 	Yaw       float64
@@ -109,7 +271,8 @@ type Ship struct {
 	// Engine sound - Index to Sounds.txt
 	EngineSound          string
 	AverageReactionDelay string
-	// Engine effect - Index to Effects.txt
+	// Engine effect - Index to Effects.txt. Not a tref, we don't
+	// parse Effects.txt and it's an index, not an ObjectID.
 	EngineEffect     string
 	EngineGlowEffect string
 	ReactorOutput    string
@@ -120,7 +283,7 @@ type Ship struct {
 	// Cockpit scene - scene containing the cockpit graphics (the real cockpit where you control the ship from)
 	CockPitScene string
 	//Possible lasers - bit mask
-	PossibleLasers int
+	PossibleLasers LaserMask
 	// Gun count - sum of count of laser parts of all gun records
 	GunCount int
 	// Weapons energy - how is it related to TLaser.txt energy?
@@ -133,7 +296,7 @@ type Ship struct {
 	// Max shield count - Maximum number of shields
 	MaxShieldCount int
 	// Possible missiles - bit mask
-	PossibleMissiles int
+	PossibleMissiles MissileMask
 	// Number of missiles (NPC) - Maximum number of missiles an NPC ship can carry
 	NumberOfMissiles int
 	MaxEngineTuning  int // One engine tuning seems to increase the speed by 10% of the minimum speed.
@@ -141,7 +304,9 @@ type Ship struct {
 	// Cargo min (buy) - minimum cargo capacity (when the ship is bought)
 	CargoMin int
 	// Cargo max - maximum cargo capacity
-	CargoMax        int
+	CargoMax int
+	// Predefined wares - index to WareLists.txt, the wares the ship
+	// comes with. Not a tref for the same reason as EngineEffect.
 	PredefinedWares string
 	// Turret descriptor - fixed length array - the reason why there is only 6 + 1 turrets
 	TurretDescriptor [6]struct {
@@ -218,6 +383,7 @@ type Ship struct {
 }
 
 type Cockpit struct {
+	rawRef
 	BodyFile               string
 	PictureID              string
 	RotX                   float64
@@ -225,8 +391,8 @@ type Cockpit struct {
 	RotZ                   float64
 	GalaxySubtype          string
 	Description            string
-	SceneFile              string
-	LaserMask              int
+	SceneFile              string // A file, not a record, see X.SceneFile.
+	LaserMask              LaserMask
 	Volume                 string
 	ProductionRelValNPC    string
 	PriceModifier1         string
@@ -244,6 +410,7 @@ func (x *X) DockByID(id string) *TDock {
 }
 
 type TDock struct {
+	rawRef
 	BodyFile               string
 	PictureID              string
 	RotX                   float64
@@ -255,7 +422,7 @@ type TDock struct {
 	DockDistance           string
 	RendezvousDistrance    string
 	ThreeDSoundVolume      string
-	SceneFile              string
+	SceneFile              string // A file, not a record, see X.SceneFile.
 	InnerScene             string
 	Race                   int
 	Explosion              string
@@ -279,6 +446,7 @@ func (x *X) GetLasers() []TLaser {
 }
 
 type TLaser struct {
+	rawRef
 	BodyFile               string
 	PictureID              string
 	RotX                   float64
@@ -301,7 +469,7 @@ type TLaser struct {
 	MinNotoriety           string
 	VideoID                string
 	Skin                   string
-	OjectID                string
+	ObjectID               string
 }
 
 func (x *X) GetShields() []TShield {
@@ -309,6 +477,7 @@ func (x *X) GetShields() []TShield {
 }
 
 type TShield struct {
+	rawRef
 	BodyFile               string
 	PictureID              string
 	Yaw                    float64
@@ -333,6 +502,7 @@ type TShield struct {
 }
 
 type TBullet struct {
+	rawRef
 	BodyFile               string
 	PictureID              string
 	Yaw                    float64
@@ -352,7 +522,7 @@ type TBullet struct {
 	SizeX                  float64
 	SizeY                  float64
 	SizeZ                  float64
-	EngineEffect           string // Effects.txt
+	EngineEffect           string // Index to Effects.txt, see Ship.EngineEffect.
 	ImpactEffect           string // Effects.txt
 	LauchEffect            string // Effects.txt
 	HullDamage             int
@@ -372,7 +542,7 @@ type TBullet struct {
 	Unknown10              int
 	Unknown11              int
 	Unknown12              int
-	Ammo                   string // TWareT.txt (or 128?)
+	Ammo                   *TWare `x3t:"tref:WaresT|WaresE|WaresB|WaresF|WaresM|WaresN"` // Ammunition, nil if none or the ware is unknown, see Problems.
 	ProductionRelValNPC    int
 	PriceModifier1         int
 	PriceModifier2         int
@@ -385,6 +555,7 @@ type TBullet struct {
 }
 
 type DummyAnimated struct {
+	rawRef
 	Id       string
 	Flags    string
 	Unknown1 int
@@ -395,3 +566,137 @@ type DummyAnimated struct {
 func (x *X) GetDum() []DummyAnimated {
 	return x.getType("DummyAnimated").v.([]DummyAnimated)
 }
+
+// Most of the files in types/ start like this.
+type objectInfo struct {
+	rawRef
+	BodyFile    string
+	PictureID   string
+	RotX        float64
+	RotY        float64
+	RotZ        float64
+	Index       string // Subtype, SG_...
+	Description string `x3t:"page:17"`
+}
+
+// Everything that can be bought and sold ends like this.
+type wareInfo struct {
+	Volume                 string
+	ProductionRelValNPC    int
+	PriceModifier1         int
+	PriceModifier2         int
+	WareClass              int
+	ProductionRelValPlayer int
+	MinNotoriety           int
+	VideoID                string
+	Skin                   string
+	ObjectID               string
+}
+
+func (x *X) GetMissiles() []TMissile {
+	return x.getType("Missiles").v.([]TMissile)
+}
+
+type TMissile struct {
+	objectInfo
+	Speed        int
+	Acceleration int
+	Sound        string // Sounds.txt
+	Lifetime     int    // Milliseconds, the range is Speed * Lifetime.
+	Damage       int
+	BlastRadius  int
+	Flags        int
+	TrailEffect  string // Effects.txt
+	GlowEffect   string // Effects.txt
+	ImpactEffect string // Effects.txt
+	RefireDelay  int    // Milliseconds between two launches.
+	wareInfo
+}
+
+func (x *X) GetFactories() []TFactory {
+	return x.getType("Factories").v.([]TFactory)
+}
+
+type TFactory struct {
+	objectInfo
+	Race          int
+	Explosion     string // Effects.txt
+	BodyExplosion string // Effects.txt
+	HullStrength  int
+	Size          int    // 0 S, 1 M, 2 L, 3 XL
+	Product       string // ObjectID of the ware made here, it can be in any of the ware files.
+	wareInfo
+}
+
+// Wares from TWareB, TWareE, TWareF, TWareM, TWareN or TWareT. class
+// is the letter after TWare.
+func (x *X) GetWares(class string) ([]TWare, error) {
+	if _, ok := typeMap["Wares"+class]; !ok {
+		return nil, fmt.Errorf("unknown ware class %q", class)
+	}
+	return x.getType("Wares" + class).v.([]TWare), nil
+}
+
+type TWare struct {
+	objectInfo
+	wareInfo
+}
+
+func (x *X) GetSpecials() []TSpecial {
+	return x.getType("Specials").v.([]TSpecial)
+}
+
+type TSpecial struct {
+	objectInfo
+	Unknown1 int
+	Unknown2 int
+	wareInfo
+}
+
+func (x *X) GetGates() []TGate {
+	return x.getType("Gates").v.([]TGate)
+}
+
+type TGate struct {
+	objectInfo
+	Explosion     string // Effects.txt
+	BodyExplosion string // Effects.txt
+	// Type - which side of the sector the gate is on, 0 north, 1
+	// south, 2 west, 3 east. Anything else is a special gate.
+	Type     int
+	ObjectID string
+}
+
+func (x *X) GetPlanets() []TPlanet {
+	return x.getType("Planets").v.([]TPlanet)
+}
+
+type TPlanet struct {
+	objectInfo
+	Size     int
+	Unknown1 int
+	ObjectID string
+}
+
+func (x *X) GetBackgrounds() []TBackground {
+	return x.getType("Backgrounds").v.([]TBackground)
+}
+
+type TBackground struct {
+	objectInfo
+	Unknown1 int
+	Unknown2 int
+	ObjectID string
+}
+
+func (x *X) GetAsteroids() []TAsteroid {
+	return x.getType("Asteroids").v.([]TAsteroid)
+}
+
+type TAsteroid struct {
+	objectInfo
+	Explosion     string // Effects.txt
+	BodyExplosion string // Effects.txt
+	HullStrength  int
+	ObjectID      string
+}
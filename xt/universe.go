@@ -2,8 +2,10 @@ package xt
 
 import (
 	"encoding/xml"
+	"fmt"
 	"log"
 	"reflect"
+	"sort"
 	"strconv"
 )
 
@@ -33,6 +35,8 @@ type Sector struct {
 	Gates      []Gate     `x3t:"ot:18"`
 	Specials   []Special  `x3t:"ot:20"`
 	Debris     []Debris   `x3t:"ot:28"`
+
+	oextra
 }
 
 type pos struct {
@@ -54,6 +58,8 @@ type Asteroid struct {
 	pos
 	rot
 	F int `x3t:"o:f"`
+
+	oextra
 }
 
 type Sun struct {
@@ -61,12 +67,16 @@ type Sun struct {
 	pos
 	Color int `x3t:"o:color"`
 	F     int `x3t:"o:f"`
+
+	oextra
 }
 
 type Background struct {
 	S     int `x3t:"o:s"`
 	Neb   int `x3t:"o:neb"`
 	Stars int `x3t:"o:stars"`
+
+	oextra
 }
 
 type Planet struct {
@@ -75,6 +85,8 @@ type Planet struct {
 	pos
 	Color int `x3t:"o:color"`
 	Fn    int `x3t:"o:fn"`
+
+	oextra
 }
 
 type race struct {
@@ -98,6 +110,8 @@ type Dock struct {
 	S string `x3t:"o:s"`
 	station
 	N int `x3t:"o:n"`
+
+	oextra
 }
 
 type Factory struct {
@@ -107,6 +121,8 @@ type Factory struct {
 	Ships     []UShip   `x3t:"ot:7"`
 	station
 	N string `x3t:"o:n"`
+
+	oextra
 }
 
 type Gate struct {
@@ -124,6 +140,8 @@ type Gate struct {
 	Gy   int `x3t:"o:gy"`
 	Gtid int `x3t:"o:gtid"` // Destination gate direction.
 	F    int `x3t:"o:f"`
+
+	oextra
 }
 
 type UShip struct { // Name conflict, sigh.
@@ -134,6 +152,8 @@ type UShip struct { // Name conflict, sigh.
 	race
 	Ships []UShip                 `x3t:"ot:7"`
 	CCs   []CustomisableContainer `x3t:"ot:23"`
+
+	oextra
 }
 
 type Special struct {
@@ -143,6 +163,8 @@ type Special struct {
 	rot
 	V int `x3t:"o:v"`
 	F int `x3t:"o:f"`
+
+	oextra
 }
 
 type CustomisableContainer struct {
@@ -159,6 +181,8 @@ type CustomisableContainer struct {
 	Food      []Ware    `x3t:"ot:14"`
 	Mineral   []Ware    `x3t:"ot:15"`
 	Tech      []Ware    `x3t:"ot:16"`
+
+	oextra
 }
 
 type Ware struct {
@@ -168,6 +192,8 @@ type Ware struct {
 	I int    `x3t:"o:i"`
 	pos
 	N int `x3t:"o:n"`
+
+	oextra
 }
 
 type Debris struct {
@@ -178,6 +204,8 @@ type Debris struct {
 	Type   int `x3t:"o:atype"` // 0 - ore, 1 - silicon, 2 - nividium, 3 - ice
 	Amount int `x3t:"o:aamount"`
 	F      int `x3t:"o:f"`
+
+	oextra
 }
 
 type Universe struct {
@@ -186,6 +214,119 @@ type Universe struct {
 	Specials  []Special `x3t:"ot:20"`
 	Factories []Factory `x3t:"ot:6"`
 	Docks     []Dock    `x3t:"ot:5"`
+
+	oextra
+}
+
+// What we need to write an object back the way it was. Embedded in
+// all the structs that are an <o> element.
+type oextra struct {
+	parsed   bool              // Came from a file, not made up in Go.
+	attrs    []string          // Names of the attributes in the order they were in.
+	unknown  map[string]string // Attributes we don't have a field for.
+	children []int             // The t of the child objects in order.
+	other    []UObject         // Child objects we don't have a field for.
+}
+
+func (e *oextra) oext() *oextra {
+	return e
+}
+
+type UAttr struct {
+	Name, Value string
+}
+
+// An object we don't know what to do with, usually something a mod
+// added. Kept as is so that it survives a round trip.
+type UObject struct {
+	T       int
+	Attrs   []UAttr // Without t.
+	Objects []UObject
+}
+
+func (o UObject) String() string {
+	s := fmt.Sprintf("<o t=\"%d\"", o.T)
+	for _, a := range o.Attrs {
+		s += fmt.Sprintf(" %s=%q", a.Name, a.Value)
+	}
+	if len(o.Objects) == 0 {
+		return s + "/>"
+	}
+	s += ">"
+	for _, c := range o.Objects {
+		s += c.String()
+	}
+	return s + "</o>"
+}
+
+// UnknownAttrs returns the attributes we don't have a field for, in
+// the order they were in.
+func (e oextra) UnknownAttrs() []UAttr {
+	ret := []UAttr{}
+	for _, n := range e.attrs {
+		if v, ok := e.unknown[n]; ok {
+			ret = append(ret, UAttr{n, v})
+		}
+	}
+	return ret
+}
+
+// UnknownObjects returns the child objects we don't have a field for.
+func (e oextra) UnknownObjects() []UObject {
+	return e.other
+}
+
+// Unknown things somewhere in an object, Path is like
+// "Factories[2].CCs[0]", empty for the object itself.
+type UnknownData struct {
+	Path    string
+	Attrs   []UAttr
+	Objects []UObject
+}
+
+// Unknown returns everything in the sector (and the objects in it)
+// that we don't have a field for.
+func (s *Sector) Unknown() []UnknownData {
+	ret := []UnknownData{}
+	unknownData("", reflect.ValueOf(s).Elem(), &ret)
+	return ret
+}
+
+func unknownData(path string, v reflect.Value, out *[]UnknownData) {
+	ext, err := oextOf(v)
+	if err != nil {
+		// Can't have been parsed, so nothing unknown in it either.
+		return
+	}
+	if a, o := ext.UnknownAttrs(), ext.UnknownObjects(); len(a) != 0 || len(o) != 0 {
+		*out = append(*out, UnknownData{path, a, o})
+	}
+	dec := decoder(v.Type())
+	ots := make([]int, 0, len(dec.ts))
+	for ot := range dec.ts {
+		ots = append(ots, ot)
+	}
+	sort.Slice(ots, func(i, j int) bool { return indexLess(dec.ts[ots[i]], dec.ts[ots[j]]) })
+	for _, ot := range ots {
+		f := v.FieldByIndex(dec.ts[ot])
+		name := fieldPath(path, v.Type().FieldByIndex(dec.ts[ot]))
+		if f.Kind() == reflect.Struct {
+			unknownData(name, f, out)
+			continue
+		}
+		for i := 0; i < f.Len(); i++ {
+			unknownData(fmt.Sprintf("%s[%d]", name, i), f.Index(i), out)
+		}
+	}
+}
+
+// Everything in the universe has to embed oextra, or we have nowhere
+// to keep what we don't know about.
+func oextOf(v reflect.Value) (*oextra, error) {
+	if e, ok := v.Addr().Interface().(interface{ oext() *oextra }); ok {
+		return e.oext(), nil
+	}
+	return nil, fmt.Errorf("%v doesn't embed oextra", v.Type())
 }
 
 func t(attrs []xml.Attr) int {
@@ -258,9 +399,11 @@ func decoder(t reflect.Type) *odecoder {
 	return dec
 }
 
-func (dec *odecoder) attrs(v reflect.Value, attrs []xml.Attr) {
+func (dec *odecoder) attrs(v reflect.Value, ext *oextra, attrs []xml.Attr) {
+	ext.parsed = true
 	for a := range attrs {
 		attr := &attrs[a]
+		ext.attrs = append(ext.attrs, attr.Name.Local)
 		if d, ok := dec.fields[attr.Name.Local]; ok {
 			switch d.k {
 			case reflect.String:
@@ -275,7 +418,10 @@ func (dec *odecoder) attrs(v reflect.Value, attrs []xml.Attr) {
 				log.Fatal("unknown field type")
 			}
 		} else if attr.Name.Local != "t" {
-			log.Printf("unknown attr %v.%v: %v", v.Type(), attr.Name.Local, attr.Value)
+			if ext.unknown == nil {
+				ext.unknown = make(map[string]string)
+			}
+			ext.unknown[attr.Name.Local] = attr.Value
 		}
 	}
 }
@@ -307,13 +453,18 @@ func nextEl(d *xml.Decoder, n string) (bool, *xml.StartElement) {
 
 func elem(d *xml.Decoder, el *xml.StartElement, v reflect.Value) {
 	dec := decoder(v.Type())
-	dec.attrs(v, el.Attr)
+	ext, err := oextOf(v)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dec.attrs(v, ext, el.Attr)
 	for {
 		next, el := nextEl(d, "o")
 		if !next {
 			return
 		}
 		ot := t(el.Attr)
+		ext.children = append(ext.children, ot)
 		if f, ok := dec.ts[ot]; ok {
 			field := v.FieldByIndex(f)
 			typ := field.Type()
@@ -328,24 +479,34 @@ func elem(d *xml.Decoder, el *xml.StartElement, v reflect.Value) {
 			}
 		} else {
 			dec.complain(v.Type(), ot)
+			ext.other = append(ext.other, uobject(d, el))
 		}
 	}
 }
 
-func (x *X) GetUniverse() (u Universe) {
-	x.universeOnce.Do(func() {
-		f := x.xf.Open("addon/maps/x3_universe.xml")
-		defer f.Close()
-		d := xml.NewDecoder(f)
-		ok, el := nextEl(d, "universe")
-		if !ok {
-			log.Fatal("not universe")
+func uobject(d *xml.Decoder, el *xml.StartElement) UObject {
+	o := UObject{T: t(el.Attr)}
+	for _, a := range el.Attr {
+		if a.Name.Local != "t" {
+			o.Attrs = append(o.Attrs, UAttr{a.Name.Local, a.Value})
 		}
+	}
+	for {
+		next, el := nextEl(d, "o")
+		if !next {
+			return o
+		}
+		o.Objects = append(o.Objects, uobject(d, el))
+	}
+}
 
-		elem(d, el, reflect.Indirect(reflect.ValueOf(&x.universe)))
-	})
-
-	return x.universe
+// GetUniverse returns the default galaxy, see GetGalaxy.
+func (x *X) GetUniverse() Universe {
+	u, err := x.GetGalaxy(DefaultGalaxy)
+	if err != nil {
+		log.Print(err)
+	}
+	return u
 }
 
 func (u Universe) SectorXY(x, y int) *Sector {
@@ -0,0 +1,115 @@
+package xt
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testUniverse = `<?xml version="1.0" standalone="yes"?>
+<universe>
+	<o t="1" x="0" y="0" r="1" size="50000" m="0" p="0" qtrade="1" qfight="0" qthink="0" qbuild="0" f="0" weird="yes">
+		<o t="2" s="1" neb="3" stars="2"/>
+		<o t="18" id="0" gid="1" x="0" y="0" z="-50000" a="0" b="0" g="0" s="0" gx="0" gy="1" gtid="0"/>
+		<o t="3" s="0" x="1" y="2" z="3" color="4"/>
+		<o t="6" s="SS_FACT" f="1" x="1" y="2" z="3" a="0" b="0" g="0" r="1" n="Mine &amp; &quot;stuff&quot;">
+			<o t="23" s="1">
+				<o t="16" s="SS_WARE" n="5"/>
+				<o t="99" what="ever"/>
+			</o>
+		</o>
+		<o t="42" s="SS_MOD_THING" x="1">
+			<o t="43" y="2"/>
+		</o>
+	</o>
+	<o t="1" x="0" y="1" r="1" size="50000" m="0" p="0" qtrade="1" qfight="0" qthink="0" qbuild="0" f="0">
+		<o t="2" s="1" neb="3" stars="2"/>
+	</o>
+</universe>
+`
+
+func TestUniverseWrite(t *testing.T) {
+	x := testX(t, map[string]string{
+		"addon/maps/x3_universe.xml": testUniverse,
+	})
+	u := x.GetUniverse()
+	if len(u.Sectors) != 2 || len(u.Sectors[0].Factories) != 1 || u.Sectors[0].Factories[0].N != `Mine & "stuff"` {
+		t.Fatalf("bad universe: %+v", u)
+	}
+	unk := u.Sectors[0].Unknown()
+	if len(unk) != 2 || unk[0].Path != "" || len(unk[0].Attrs) != 1 || unk[0].Attrs[0] != (UAttr{"weird", "yes"}) ||
+		len(unk[0].Objects) != 1 || unk[0].Objects[0].String() != `<o t="42" s="SS_MOD_THING" x="1"><o t="43" y="2"/></o>` ||
+		unk[1].Path != "Factories[0].CCs[0]" || unk[1].Objects[0].T != 99 {
+		t.Errorf("bad unknown data: %+v", unk)
+	}
+	var b bytes.Buffer
+	if err := u.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != testUniverse {
+		t.Errorf("round trip changed the file:\n%s", b.String())
+	}
+
+	// Edit it, that's the point.
+	u.Sectors[1].Qfight = 3
+	u.Sectors[0].Suns = nil
+	u.Sectors = append(u.Sectors, Sector{X: 5, Y: 6, Size: 1000})
+	b.Reset()
+	if err := u.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	s := b.String()
+	for _, want := range []string{
+		`qfight="3" qthink`,
+		"\t<o t=\"1\" f=\"0\" x=\"5\" y=\"6\" r=\"0\" size=\"1000\" m=\"0\" p=\"0\" qtrade=\"0\" qfight=\"0\" qthink=\"0\" qbuild=\"0\">\n" +
+			"\t\t<o t=\"2\" s=\"0\" neb=\"0\" stars=\"0\"/>\n\t</o>\n</universe>\n",
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("no %q in:\n%s", want, s)
+		}
+	}
+	if strings.Contains(s, `<o t="3"`) {
+		t.Errorf("removed sun is still there:\n%s", s)
+	}
+}
+
+// A struct without oextra is an error, not a panic.
+func TestNoOextra(t *testing.T) {
+	var s struct {
+		X int `x3t:"o:x"`
+	}
+	uw := uWriter{w: bufio.NewWriter(io.Discard)}
+	uw.elem("o", 1, reflect.ValueOf(&s).Elem(), 0)
+	if uw.err == nil || !strings.Contains(uw.err.Error(), "oextra") {
+		t.Errorf("got %v", uw.err)
+	}
+	var out []UnknownData
+	unknownData("", reflect.ValueOf(&s).Elem(), &out)
+	if len(out) != 0 {
+		t.Errorf("unknown data: %v", out)
+	}
+}
+
+func TestGalaxies(t *testing.T) {
+	x := testX(t, map[string]string{
+		"addon/maps/x3_universe.xml": testUniverse,
+		"maps/x3_custom_1.xml":       "<universe>\n\t<o t=\"1\" x=\"7\" y=\"7\"/>\n</universe>\n",
+		"addon/maps/director.xml":    "<?xml version=\"1.0\"?>\n<director/>\n",
+	})
+	if g := x.Galaxies(); !reflect.DeepEqual(g, []string{"x3_custom_1", DefaultGalaxy}) {
+		t.Errorf("galaxies: %v", g)
+	}
+	u, err := x.WithLanguage(49).GetGalaxy("x3_custom_1")
+	if err != nil || len(u.Sectors) != 1 || u.SectorXY(7, 7) == nil {
+		t.Errorf("x3_custom_1: %v %+v", err, u)
+	}
+	if len(x.GetUniverse().Sectors) != 2 {
+		t.Errorf("default galaxy: %+v", x.GetUniverse())
+	}
+	if _, err := x.GetGalaxy("director"); err == nil {
+		t.Errorf("director.xml is not a galaxy")
+	}
+}
@@ -0,0 +1,215 @@
+package xt
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Writing the universe back to x3_universe.xml, driven by the same
+// o: and ot: tags the decoder uses.
+
+type uWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+// Write writes the universe in the format of x3_universe.xml.
+// Objects that were read from a file keep the order of their
+// attributes and children and the attributes we don't know about.
+// Objects made up in Go get all their attributes, in the order of
+// the fields.
+func (u Universe) Write(w io.Writer) error {
+	uw := uWriter{w: bufio.NewWriter(w)}
+	uw.w.WriteString("<?xml version=\"1.0\" standalone=\"yes\"?>\n")
+	uw.elem("universe", -1, reflect.ValueOf(&u).Elem(), 0)
+	if uw.err != nil {
+		return uw.err
+	}
+	return uw.w.Flush()
+}
+
+var attrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "\n", "&#10;", "\r", "&#13;", "\t", "&#9;")
+
+func attrEscape(s string) string {
+	return attrEscaper.Replace(s)
+}
+
+// ot is the t attribute, -1 for the root.
+func (uw *uWriter) elem(name string, ot int, v reflect.Value, depth int) {
+	dec := decoder(v.Type())
+	ext, err := oextOf(v)
+	if err != nil {
+		if uw.err == nil {
+			uw.err = err
+		}
+		return
+	}
+	indent := strings.Repeat("\t", depth)
+
+	uw.w.WriteString(indent + "<" + name)
+	done := make(map[string]bool)
+	attr := func(n string) {
+		if done[n] {
+			return
+		}
+		done[n] = true
+		switch d, ok := dec.fields[n]; {
+		case n == "t":
+			if ot != -1 {
+				fmt.Fprintf(uw.w, " t=\"%d\"", ot)
+			}
+		case ok:
+			fmt.Fprintf(uw.w, " %s=\"%s\"", n, attrEscape(uw.oattr(v.FieldByIndex(d.i))))
+		default:
+			if val, ok := ext.unknown[n]; ok {
+				fmt.Fprintf(uw.w, " %s=\"%s\"", n, attrEscape(val))
+			}
+		}
+	}
+	if !ext.parsed {
+		attr("t")
+	}
+	for _, n := range ext.attrs {
+		attr(n)
+	}
+	// Attributes that weren't in the file. Don't add zeroes to parsed
+	// objects, the file didn't have them for a reason.
+	for _, n := range dec.order() {
+		if ext.parsed && v.FieldByIndex(dec.fields[n].i).IsZero() {
+			continue
+		}
+		attr(n)
+	}
+
+	children := uw.children(v, dec, ext)
+	if len(children) == 0 {
+		uw.w.WriteString("/>\n")
+		return
+	}
+	uw.w.WriteString(">\n")
+	for _, c := range children {
+		if c.u != nil {
+			uw.uobject(c.u, depth+1)
+		} else {
+			uw.elem("o", c.ot, c.v, depth+1)
+		}
+	}
+	uw.w.WriteString(indent + "</" + name + ">\n")
+}
+
+func (uw *uWriter) uobject(o *UObject, depth int) {
+	indent := strings.Repeat("\t", depth)
+	fmt.Fprintf(uw.w, "%s<o t=\"%d\"", indent, o.T)
+	for _, a := range o.Attrs {
+		fmt.Fprintf(uw.w, " %s=\"%s\"", a.Name, attrEscape(a.Value))
+	}
+	if len(o.Objects) == 0 {
+		uw.w.WriteString("/>\n")
+		return
+	}
+	uw.w.WriteString(">\n")
+	for i := range o.Objects {
+		uw.uobject(&o.Objects[i], depth+1)
+	}
+	uw.w.WriteString(indent + "</o>\n")
+}
+
+func (uw *uWriter) oattr(f reflect.Value) string {
+	switch f.Kind() {
+	case reflect.String:
+		return f.String()
+	case reflect.Int:
+		return strconv.FormatInt(f.Int(), 10)
+	}
+	if uw.err == nil {
+		uw.err = fmt.Errorf("can't write %v", f.Type())
+	}
+	return ""
+}
+
+type ochild struct {
+	ot int
+	v  reflect.Value
+	u  *UObject // For the objects we don't know, then v is not set.
+}
+
+// The child objects to write. The ones we read keep their order, the
+// rest come after them in field order.
+func (uw *uWriter) children(v reflect.Value, dec *odecoder, ext *oextra) []ochild {
+	ret := []ochild{}
+	next := make(map[int]int)
+	take := func(ot int) bool {
+		f, ok := dec.ts[ot]
+		if !ok {
+			return false
+		}
+		field := v.FieldByIndex(f)
+		i := next[ot]
+		switch field.Kind() {
+		case reflect.Slice:
+			if i >= field.Len() {
+				return false
+			}
+			ret = append(ret, ochild{ot: ot, v: field.Index(i)})
+		case reflect.Struct:
+			if i > 0 {
+				return false
+			}
+			ret = append(ret, ochild{ot: ot, v: field})
+		}
+		next[ot] = i + 1
+		return true
+	}
+	other := 0
+	for _, ot := range ext.children {
+		if _, ok := dec.ts[ot]; ok {
+			take(ot)
+		} else if other < len(ext.other) {
+			ret = append(ret, ochild{ot: ot, u: &ext.other[other]})
+			other++
+		}
+	}
+	for ; other < len(ext.other); other++ {
+		ret = append(ret, ochild{ot: ext.other[other].T, u: &ext.other[other]})
+	}
+	ots := make([]int, 0, len(dec.ts))
+	for ot := range dec.ts {
+		ots = append(ots, ot)
+	}
+	sort.Slice(ots, func(i, j int) bool { return indexLess(dec.ts[ots[i]], dec.ts[ots[j]]) })
+	for _, ot := range ots {
+		field := v.FieldByIndex(dec.ts[ot])
+		if field.Kind() == reflect.Struct && ext.parsed && (next[ot] > 0 || field.IsZero()) {
+			// A parsed object didn't have it, don't make it up.
+			continue
+		}
+		for take(ot) {
+		}
+	}
+	return ret
+}
+
+// Attribute names in field order.
+func (dec *odecoder) order() []string {
+	ret := make([]string, 0, len(dec.fields))
+	for n := range dec.fields {
+		ret = append(ret, n)
+	}
+	sort.Slice(ret, func(i, j int) bool { return indexLess(dec.fields[ret[i]].i, dec.fields[ret[j]].i) })
+	return ret
+}
+
+// Compare field indexes.
+func indexLess(a, b []int) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
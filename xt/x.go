@@ -8,20 +8,60 @@ import (
 // Each thing we access is loaded and parsed on demand. To synchronize
 // this, each member is protected by a sync.Once.
 type X struct {
-	xf Xfiles
+	xf      Xfiles
+	lang    int
+	lenient bool
 
-	textOnce sync.Once
-	text     Text
+	// Views of the same installation in other languages. Only in the
+	// X that NewX returned, the views have base pointing to it.
+	base    *X
+	viewsMu sync.Mutex
+	views   map[int]*X
+
+	textOnce   sync.Once
+	text       Text
+	textSrc    map[int]map[int]string // Which file each text came from.
+	textTitles map[int]string
 
 	typeCache map[string]*typeCache
 
-	universeOnce sync.Once
-	universe     Universe
+	laserBits, missileBits maskTable
+	mountIndex             mountIndex
+
+	galaxiesOnce sync.Once
+	galaxyNames  []string
+	galaxyMu     sync.Mutex
+	galaxies     map[string]*galaxyCache
 }
 
 // Get all the information we can get from an X3 installation.
-func NewX(dir string) *X {
-	x := &X{xf: XFiles(dir)}
+func NewX(dir string) (*X, error) {
+	return NewXOptions(dir, Options{})
+}
+
+type Options struct {
+	// Skip records in types/ that can't be parsed instead of stopping
+	// at the first one and only keeping the records before it, see
+	// Problems. The bad records stay in the slices as zero values so
+	// that the indexes of the others don't change, but they can't be
+	// found by ObjectID.
+	Lenient bool
+}
+
+func NewXOptions(dir string, opt Options) (*X, error) {
+	xf, err := XFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	x := newX(xf, English)
+	x.lenient = opt.Lenient
+	return x, nil
+}
+
+const English = 44
+
+func newX(xf Xfiles, lang int) *X {
+	x := &X{xf: xf, lang: lang}
 	x.typeCache = make(map[string]*typeCache)
 	for k := range typeMap {
 		x.typeCache[k] = &typeCache{}
@@ -29,10 +69,50 @@ func NewX(dir string) *X {
 	return x
 }
 
-func (x *X) Open(f string) io.ReadCloser {
+// The language of the text, 44 is English, 49 German, 7 Russian, etc.
+func (x *X) Language() int {
+	return x.lang
+}
+
+// WithLanguage returns a view of the same installation with all the
+// text in another language. Everything that contains text (which is
+// all the types) is loaded again for it, the files and the universe
+// are shared.
+func (x *X) WithLanguage(lang int) *X {
+	b := x
+	if x.base != nil {
+		b = x.base
+	}
+	if lang == b.lang {
+		return b
+	}
+	b.viewsMu.Lock()
+	defer b.viewsMu.Unlock()
+	if v := b.views[lang]; v != nil {
+		return v
+	}
+	if b.views == nil {
+		b.views = make(map[int]*X)
+	}
+	v := newX(b.xf, lang)
+	v.lenient = b.lenient
+	v.base = b
+	b.views[lang] = v
+	return v
+}
+
+func (x *X) Open(f string) (io.ReadCloser, error) {
 	return x.xf.Open(f)
 }
 
+func (x *X) OpenRaw(f string) (io.ReadCloser, error) {
+	return x.xf.OpenRaw(f)
+}
+
+func (x *X) Which(f string) []Source {
+	return x.xf.Which(f)
+}
+
 func (x *X) Map(f func(string, string)) {
 	x.xf.Map(f)
 }
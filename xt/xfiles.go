@@ -3,67 +3,265 @@ package xt
 import (
 	"bufio"
 	"compress/gzip"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 	"unsafe"
 )
 
 // Access files according to the rules as I understand them.
-// cat/dat files in number order, then actual directories.
+// Base game cat/dat files in number order, then the addon cat/dat
+// files in number order, then actual directories.
 // Latter overriding the earlier.
+//
+// The addon directory is where AP keeps its files, anything the addon
+// doesn't have comes from the base game. So "addon/types/TShips.txt"
+// falls back to "types/TShips.txt" if there is no such file in the
+// addon. This also makes plain TC and Reunion installations work
+// since everything we look for is in addon/.
 
 type Xdata interface {
-	Open() io.ReadCloser
+	Open() (io.ReadCloser, error)
+}
+
+// The kinds of errors the file layer returns. They always come
+// wrapped in a *FileError, check for them with errors.Is.
+var (
+	ErrNotFound   = errors.New("file not found")
+	ErrCorrupt    = errors.New("corrupt archive")
+	ErrScrambling = errors.New("unknown scrambling")
+	ErrMissingDat = errors.New("cat without dat")
+)
+
+type FileError struct {
+	Path string
+	Kind error // One of the Err* above.
+	Err  error // The underlying error, if any.
+}
+
+func (e *FileError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v: %v", e.Path, e.Kind, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.Path, e.Kind)
+}
+
+// So that io/fs users see a missing file as missing.
+func (e *FileError) Is(target error) bool {
+	return e.Kind == ErrNotFound && target == fs.ErrNotExist
+}
+
+func (e *FileError) Unwrap() []error {
+	if e.Err != nil {
+		return []error{e.Kind, e.Err}
+	}
+	return []error{e.Kind}
 }
 
 type Xfiles struct {
-	f map[string]map[string]Xdata // [directory][file]
+	f    map[string]map[string]Xdata // [directory][file]
+	src  map[string][]Source         // [directory/file], in the order they were added
+	dirs map[string][]string         // [directory] -> subdirectories
 }
 
-func XFiles(dir string) Xfiles {
-	ret := Xfiles{f: make(map[string]map[string]Xdata)}
+// Where a file came from. Paths are relative to the installation
+// and use slashes.
+type Source struct {
+	Archive string // The cat file, empty for loose files.
+	File    string // Path in the archive or on disk, as stored.
+	Offset  int64  // Offset in the dat file.
+	Size    int64  // Size as stored, before unpacking.
+	ModTime time.Time
+}
+
+func (s Source) String() string {
+	if s.Archive == "" {
+		return fmt.Sprintf("%s (size %d)", s.File, s.Size)
+	}
+	return fmt.Sprintf("%s:%s (offset %d, size %d)", s.Archive, s.File, s.Offset, s.Size)
+}
+
+// Base is the name of the file as it is stored, without the
+// directories. For pck files that's with the .pck suffix.
+func (s Source) Base() string {
+	return path.Base(slashPath(s.File))
+}
+
+// Paths in cat files have backslashes.
+func slashPath(p string) string {
+	return strings.Replace(p, "\\", "/", -1)
+}
+
+func XFiles(dir string) (Xfiles, error) {
+	ret := Xfiles{f: make(map[string]map[string]Xdata), src: make(map[string][]Source)}
 	// 01.{cat,dat}, 02.{cat,dat}, etc. stop at the first that doesn't exist.
-	// XXX - how are the non-addon directory cat files involved here?
-	for i := 1; ret.parseCD(filepath.Join(dir, "addon", fmt.Sprintf("%.2d", i))); i++ {
+	for _, d := range []string{"", "addon"} {
+		for i := 1; ; i++ {
+			ok, err := ret.parseCD(dir, filepath.Join(d, fmt.Sprintf("%.2d", i)))
+			if err != nil {
+				return ret, err
+			}
+			if !ok {
+				break
+			}
+		}
 	}
 	// Now, the normal files.
-	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
 		if info.IsDir() {
 			return nil
 		}
 
 		relpath, err := filepath.Rel(dir, path)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
-		ret.add(relpath, fs(path))
+		ret.add(relpath, diskFile(path), Source{File: filepath.ToSlash(relpath), Size: info.Size(), ModTime: info.ModTime()})
 		return nil
 	})
+	ret.mkdirs()
+	return ret, err
+}
+
+const addonPrefix = "addon/"
+
+func (xf *Xfiles) get(fname string) Xdata {
+	d, f := "", fname
+	if a := strings.LastIndex(fname, "/"); a != -1 {
+		d, f = fname[:a], fname[a+1:]
+	}
+	return xf.f[d][f]
+}
+
+// Which file we really mean, with the fallback from the addon to the
+// base game.
+func (xf *Xfiles) resolve(fname string) string {
+	if xf.get(fname) == nil && strings.HasPrefix(fname, addonPrefix) {
+		if base := strings.TrimPrefix(fname, addonPrefix); xf.get(base) != nil {
+			return base
+		}
+	}
+	return fname
+}
+
+func (xf *Xfiles) lookup(fname string) Xdata {
+	return xf.get(xf.resolve(fname))
+}
+
+// The base game directory an addon directory falls back to.
+func baseDir(dir string) (string, bool) {
+	if !strings.HasPrefix(dir+"/", addonPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(dir, "addon"), "/"), true
+}
+
+// All the directories with files in them, including the addon
+// directories that only have files through the fallback. Sorted.
+func (xf *Xfiles) fileDirs() []string {
+	seen := make(map[string]bool)
+	for d := range xf.f {
+		seen[d] = true
+		if _, ok := baseDir(d); !ok {
+			seen[strings.TrimSuffix(addonPrefix+d, "/")] = true
+		}
+	}
+	ret := make([]string, 0, len(seen))
+	for d := range seen {
+		ret = append(ret, d)
+	}
+	sort.Strings(ret)
 	return ret
 }
 
-func (xf *Xfiles) Open(fname string) io.ReadCloser {
-	a := strings.LastIndex(fname, "/")
-	if dir := xf.f[fname[:a]]; dir == nil {
-		return nil
-	} else if f := dir[fname[a+1:]]; f == nil {
-		return nil
-	} else {
-		return f.Open()
+// The files in a directory. For addon directories this includes the
+// files from the base game the addon doesn't override.
+func (xf *Xfiles) files(dir string) []string {
+	ret := []string{}
+	for fn := range xf.f[dir] {
+		ret = append(ret, fn)
+	}
+	if base, ok := baseDir(dir); ok {
+		for fn := range xf.f[base] {
+			if xf.f[dir][fn] == nil {
+				ret = append(ret, fn)
+			}
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func (xf *Xfiles) Open(fname string) (io.ReadCloser, error) {
+	xd := xf.lookup(fname)
+	if xd == nil {
+		return nil, &FileError{Path: fname, Kind: ErrNotFound}
 	}
+	r, err := xd.Open()
+	return r, fileErrorPath(err, fname)
+}
 
+// Open a file the way it's stored, without descrambling or
+// unpacking pck files.
+func (xf *Xfiles) OpenRaw(fname string) (io.ReadCloser, error) {
+	xd := xf.lookup(fname)
+	if xd == nil {
+		return nil, &FileError{Path: fname, Kind: ErrNotFound}
+	}
+	r, err := rawXdata(xd).Open()
+	return r, fileErrorPath(err, fname)
 }
 
+// The decoders don't know which file they are decoding, fill in the
+// path in their errors.
+func fileErrorPath(err error, fname string) error {
+	var fe *FileError
+	if errors.As(err, &fe) && fe.Path == "" {
+		fe.Path = fname
+	}
+	return err
+}
+
+// Strip off the decoding layers from a file.
+func rawXdata(xd Xdata) Xdata {
+	switch d := xd.(type) {
+	case pck:
+		return d.xd
+	case bobOpener:
+		return d.xd
+	}
+	return xd
+}
+
+// Which files provided fname, in the order they were loaded. The last
+// one is the one that Open will use, the earlier ones were overridden.
+func (xf *Xfiles) Which(fname string) []Source {
+	if !strings.HasPrefix(fname, addonPrefix) {
+		return xf.src[fname]
+	}
+	base := strings.TrimPrefix(fname, addonPrefix)
+	return append(append([]Source{}, xf.src[base]...), xf.src[fname]...)
+}
+
+// Map calls f for every file the same way Open sees them, so the
+// addon directories include the base game files they fall back to.
 func (xf *Xfiles) Map(f func(string, string)) {
-	for dir := range xf.f {
-		for fn := range xf.f[dir] {
+	for _, dir := range xf.fileDirs() {
+		for _, fn := range xf.files(dir) {
 			f(dir, fn)
 		}
 	}
@@ -84,8 +282,24 @@ var pckMap = map[string]string{
 	".":         "wtf",
 }
 
+// pbd and pbb files are packed the same way as pck files, but they
+// keep their names when we access them.
+var packedExt = map[string]string{
+	".pbd": ".bod",
+	".pbb": ".bob",
+}
+
+// The name a file should have when unpacked from the game files.
+func PlainName(fn string) string {
+	ext := path.Ext(fn)
+	if pe := packedExt[ext]; pe != "" {
+		return strings.TrimSuffix(fn, ext) + pe
+	}
+	return fn
+}
+
 // Must be called with native paths, we'll convert back to slashes.
-func (xf *Xfiles) add(fn string, xd Xdata) {
+func (xf *Xfiles) add(fn string, xd Xdata, src Source) {
 	d, f := filepath.Split(fn)
 	switch filepath.Ext(f) {
 	case ".pck":
@@ -108,26 +322,37 @@ func (xf *Xfiles) add(fn string, xd Xdata) {
 		xf.f[d] = make(map[string]Xdata)
 	}
 	xf.f[d][f] = xd
-
+	f = joinPath(d, f)
+	xf.src[f] = append(xf.src[f], src)
 }
 
 var pathRe = regexp.MustCompile(`(.+) ([0-9]+)`)
 
-func (xf *Xfiles) parseCD(basename string) bool {
+func (xf *Xfiles) parseCD(dir, name string) (ok bool, err error) {
+	basename := filepath.Join(dir, name)
+	addon := strings.HasPrefix(filepath.ToSlash(name), addonPrefix)
 	fc, err := os.Open(basename + ".cat")
 	if err != nil {
-		return false
+		return false, nil
 	}
 	defer fc.Close()
 	fd, err := os.Open(basename + ".dat")
 	if err != nil {
-		log.Fatalf("cat(%s) without dat: %v", basename, err)
+		return false, &FileError{Path: basename + ".cat", Kind: ErrMissingDat, Err: err}
 	}
-
 	// We deliberately leak the dat file descriptors. There won't
 	// be that many of them, so it's not worth the effort to
 	// figure out the logic of when they should be opened and
-	// closed.
+	// closed. Unless the archive is broken, then nothing uses it.
+	defer func() {
+		if err != nil {
+			fd.Close()
+		}
+	}()
+	fi, err := fd.Stat()
+	if err != nil {
+		return false, err
+	}
 
 	s := bufio.NewScanner(&stupidDescramblerOff{r: fc, cookie: 219, addOff: true})
 	s.Scan() // throw away the first line
@@ -140,12 +365,24 @@ func (xf *Xfiles) parseCD(basename string) bool {
 		}
 		i, err := strconv.ParseInt(string(split[2]), 10, 64)
 		if err != nil {
-			log.Fatal(err)
+			return false, &FileError{Path: basename + ".cat", Kind: ErrCorrupt, Err: err}
+		}
+		if off+i > fi.Size() {
+			return false, &FileError{Path: basename + ".cat", Kind: ErrCorrupt, Err: fmt.Errorf("%s is past the end of the dat file", split[1])}
 		}
-		xf.add(filepath.FromSlash(split[1]), cd{fd, off, i})
+		src := Source{Archive: filepath.ToSlash(name) + ".cat", File: split[1], Offset: off, Size: i, ModTime: fi.ModTime()}
+		// The files in the addon archives are in the addon.
+		fn := slashPath(split[1])
+		if addon && !strings.HasPrefix(fn, addonPrefix) {
+			fn = addonPrefix + fn
+		}
+		xf.add(filepath.FromSlash(fn), cd{fd, off, i}, src)
 		off += i
 	}
-	return true
+	if err := s.Err(); err != nil {
+		return false, &FileError{Path: basename + ".cat", Kind: ErrCorrupt, Err: err}
+	}
+	return true, nil
 }
 
 type stupidDescrambler struct {
@@ -224,18 +461,18 @@ func (d *stupidDescramblerOff) Close() error {
 	return d.r.Close()
 }
 
-type fs string
+type diskFile string
 
-func (fn fs) Open() io.ReadCloser {
+func (fn diskFile) Open() (io.ReadCloser, error) {
 	f, err := os.Open(string(fn))
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	return f
+	return f, nil
 }
 
 type readerWithAt interface {
-	io.Reader
+	io.ReadSeeker
 	io.ReaderAt
 }
 
@@ -252,68 +489,119 @@ type cd struct {
 	off, n int64
 }
 
-func (c cd) Open() io.ReadCloser {
-	return nopclose{io.NewSectionReader(c.f, c.off, c.n)}
+func (c cd) Open() (io.ReadCloser, error) {
+	return nopclose{io.NewSectionReader(c.f, c.off, c.n)}, nil
 }
 
 type pck struct {
 	xd Xdata
 }
 
-type pckReader struct {
-	zr *gzip.Reader
-	r  io.ReadCloser
+// The ways pck files are scrambled that we know of. All of them are
+// gzip files xored with a cookie, some with a key byte in front.
+type PckScrambling int
+
+const (
+	PckXOR       PckScrambling = iota // gzip data xor cookie
+	PckKeyXOR                         // key byte, then gzip data xor cookie
+	PckXOROff                         // gzip data xor (cookie + offset)
+	PckKeyXOROff                      // key byte, then gzip data xor (cookie + offset)
+)
+
+func (s PckScrambling) String() string {
+	switch s {
+	case PckXOR:
+		return "xor"
+	case PckKeyXOR:
+		return "key+xor"
+	case PckXOROff:
+		return "xor+offset"
+	case PckKeyXOROff:
+		return "key+xor+offset"
+	}
+	return fmt.Sprintf("PckScrambling(%d)", int(s))
 }
 
-func (p pck) Open() io.ReadCloser {
-	r := p.xd.Open()
-	ra := r.(io.ReaderAt)
-	// 31, 139
-	hdr := make([]byte, 4, 4)
-	_, err := ra.ReadAt(hdr, 0)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	// Figure out the stupid scrambling.
-	//
-	// What we're looking for is the first two bytes of a gzip
-	// header - 31, 139 (and 8 because we expect deflate).
-	//
-	// This code has the potential of giving false positives. It
-	// is in fact trivial to generate a header that will break
-	// this and no matter which comparison is done first, it will
-	// be the wrong one. This seems to work for now.
-	rs := &stupidDescramblerOff{r: r}
+// Figure out the stupid scrambling from the first four bytes of a
+// pck file.
+//
+// What we're looking for is the first two bytes of a gzip
+// header - 31, 139 (and 8 because we expect deflate).
+//
+// This code has the potential of giving false positives. It
+// is in fact trivial to generate a header that will break
+// this and no matter which comparison is done first, it will
+// be the wrong one. This seems to work for now.
+func pckScrambling(hdr []byte) (PckScrambling, byte, bool) {
 	if cookie := (hdr[0] ^ 31); hdr[1]^cookie == 139 && hdr[2]^cookie == 8 {
 		// the first two bytes are a gzip header xor cookie.
-		rs.cookie = cookie
+		return PckXOR, cookie, true
 	} else if cookie := (hdr[1] ^ 31); hdr[2]^cookie == 139 && hdr[3]^cookie == 8 {
 		// apparently the cookie can be in the first byte and it's xor something.
 		// it's easier to just ignore it and just figure it out from the header.
+		return PckKeyXOR, cookie, true
+	} else if cookie := (hdr[0] ^ 31); hdr[1]^(cookie+1) == 139 && hdr[2]^(cookie+2) == 8 {
+		// byte n is xor cookie+n, the same way stupidDescramblerOff
+		// undoes it. This used to look for 138 with the wrong
+		// offsets, which didn't match what the decoder does.
+		return PckXOROff, cookie, true
+	} else if cookie := (hdr[1] ^ 31); hdr[2]^(cookie+1) == 139 && hdr[3]^(cookie+2) == 8 {
+		// the offset starts counting after the key byte.
+		return PckKeyXOROff, cookie, true
+	}
+	return PckXOR, 0, false
+}
+
+// Figure out how an existing pck file is scrambled.
+func PckInfo(r io.ReaderAt) (PckScrambling, byte, error) {
+	hdr := make([]byte, 4, 4)
+	_, err := r.ReadAt(hdr, 0)
+	if err != nil {
+		return 0, 0, &FileError{Kind: ErrCorrupt, Err: err}
+	}
+	sc, cookie, ok := pckScrambling(hdr)
+	if !ok {
+		return 0, 0, &FileError{Kind: ErrScrambling}
+	}
+	return sc, cookie, nil
+}
+
+type pckReader struct {
+	zr *gzip.Reader
+	r  io.ReadCloser
+}
+
+func (p pck) Open() (io.ReadCloser, error) {
+	r, err := p.xd.Open()
+	if err != nil {
+		return nil, err
+	}
+	ra, ok := r.(io.ReaderAt)
+	if !ok {
+		r.Close()
+		return nil, fmt.Errorf("pck: %T is not a ReaderAt", r)
+	}
+	sc, cookie, err := PckInfo(ra)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
 
+	rs := &stupidDescramblerOff{r: r}
+	if sc == PckKeyXOR || sc == PckKeyXOROff {
 		// eat first byte
 		tmp := make([]byte, 1, 1)
 		_, _ = r.Read(tmp)
-		rs.cookie = cookie
-	} else if cookie := (hdr[0] ^ 31); hdr[1]^(cookie+1) == 138 && hdr[2]^(cookie+2) == 8 {
-		rs.cookie = cookie
-		rs.addOff = true
-	} else if cookie := (hdr[1] ^ 31); hdr[2]^(cookie+2) == 138 && hdr[3]^(cookie+3) == 8 {
-		tmp := make([]byte, 1, 1)
-		_, _ = r.Read(tmp)
-
-		rs.cookie = cookie
-		rs.addOff = true
-	} else {
-		log.Printf("unknown scrambling method. fingers crossed.")
 	}
+	rs.cookie = cookie
+	rs.addOff = sc == PckXOROff || sc == PckKeyXOROff
 
 	zr, err := gzip.NewReader(rs)
 	if err != nil {
-		log.Fatal("gzip.NewReader: ", err)
+		r.Close()
+		return nil, &FileError{Kind: ErrCorrupt, Err: err}
 	}
-	return &pckReader{zr, r}
+	return &pckReader{zr, r}, nil
 }
 
 func (pr *pckReader) Read(p []byte) (int, error) {
@@ -329,6 +617,10 @@ type bobOpener struct {
 	xd Xdata
 }
 
-func (b bobOpener) Open() io.ReadCloser {
-	return newSD(b.xd.Open(), 51)
+func (b bobOpener) Open() (io.ReadCloser, error) {
+	r, err := b.xd.Open()
+	if err != nil {
+		return nil, err
+	}
+	return newSD(r, 51), nil
 }
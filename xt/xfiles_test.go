@@ -0,0 +1,529 @@
+package xt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// Synthetic files only, no game data in here. Just like in the game
+// the paths inside the addon archives start with "addon/". Paths
+// without it are put under addon/ too, see TestAddonNoPrefix.
+var testFiles = map[string]string{
+	"addon/types/TFoo.txt":       "22;1;\nfoo;bar;\n",
+	"addon/t/0001-L044.xml":      "<?xml version=\"1.0\"?><language id=\"44\"></language>",
+	"addon/maps/name with space": "x",
+	"addon/objects/empty.txt":    "",
+}
+
+func readAll(t *testing.T, xf *Xfiles, name string) string {
+	t.Helper()
+	f, err := xf.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("read %s: %v", name, err)
+	}
+	return string(b)
+}
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for n, c := range files {
+		fn := filepath.Join(root, filepath.FromSlash(n))
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, []byte(c), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCatDatRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	writeFiles(t, src, testFiles)
+
+	inst := t.TempDir()
+	if err := os.Mkdir(filepath.Join(inst, "addon"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteCatDat(filepath.Join(inst, "addon", "01"), src); err != nil {
+		t.Fatal(err)
+	}
+
+	xf, err := XFiles(inst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n, c := range testFiles {
+		if got := readAll(t, &xf, n); got != c {
+			t.Errorf("%s: got %q, want %q", n, got, c)
+		}
+	}
+}
+
+// The names in the cat have backslashes, like the game's own cats.
+func TestCatWriterNames(t *testing.T) {
+	var cat, dat bytes.Buffer
+	cw := NewCatWriter(&cat, &dat, "01.dat")
+	if err := cw.Add("types/TShips.pck", strings.NewReader("abc")); err != nil {
+		t.Fatal(err)
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b := cat.Bytes()
+	for i := range b {
+		b[i] ^= 219 + byte(i)
+	}
+	if want := "01.dat\ntypes\\TShips.pck 3\n"; string(b) != want {
+		t.Errorf("cat is %q, want %q", b, want)
+	}
+}
+
+// The archive can be written into the directory it packs.
+func TestCatDatInDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"types/TShips.txt": "ships"})
+	if err := WriteCatDat(filepath.Join(dir, "01"), dir); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "01.cat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range b {
+		b[i] ^= 219 + byte(i)
+	}
+	if want := "01.dat\ntypes\\TShips.txt 5\n"; string(b) != want {
+		t.Errorf("cat is %q, want %q", b, want)
+	}
+}
+
+// Files in the addon archives are in the addon, whether their paths
+// start with "addon/" or not.
+func TestAddonNoPrefix(t *testing.T) {
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{"types/TFoo.txt": "foo", "addon/types/TBar.txt": "bar"})
+	inst := t.TempDir()
+	if err := os.Mkdir(filepath.Join(inst, "addon"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteCatDat(filepath.Join(inst, "addon", "01"), src); err != nil {
+		t.Fatal(err)
+	}
+	xf, err := XFiles(inst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, &xf, "addon/types/TFoo.txt"); got != "foo" {
+		t.Errorf("no prefix: %q", got)
+	}
+	if got := readAll(t, &xf, "addon/types/TBar.txt"); got != "bar" {
+		t.Errorf("prefix: %q", got)
+	}
+	if _, err := xf.Open("types/TFoo.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("addon file in the base game: %v", err)
+	}
+}
+
+func TestCatWriterBadName(t *testing.T) {
+	cw := NewCatWriter(io.Discard, io.Discard, "01.dat")
+	if err := cw.Add("foo\nbar", bytes.NewReader(nil)); err == nil {
+		t.Error("expected error for name with newline")
+	}
+}
+
+// Headers scrambled by hand the way stupidDescramblerOff undoes it,
+// not with WritePck, so the two can't agree on the wrong thing.
+func TestPckScrambling(t *testing.T) {
+	gz := []byte{31, 139, 8}
+	c := byte(0x5a)
+	off := func(key bool) []byte {
+		h := []byte{}
+		if key {
+			h = append(h, 0xff)
+		}
+		for i, b := range gz {
+			h = append(h, b^(c+byte(i)))
+		}
+		return append(h, 0)
+	}
+	for _, tc := range []struct {
+		hdr []byte
+		sc  PckScrambling
+	}{
+		{[]byte{31 ^ c, 139 ^ c, 8 ^ c, 0}, PckXOR},
+		{[]byte{0xff, 31 ^ c, 139 ^ c, 8 ^ c}, PckKeyXOR},
+		{off(false), PckXOROff},
+		{off(true), PckKeyXOROff},
+	} {
+		if sc, cookie, ok := pckScrambling(tc.hdr); !ok || sc != tc.sc || cookie != c {
+			t.Errorf("% x: got %v/%x/%v, want %v/%x", tc.hdr, sc, cookie, ok, tc.sc, c)
+		}
+	}
+}
+
+// A whole key+xor+offset pck file with cookie 0x5a, put together
+// outside of Go (python's gzip, then the key byte and the scrambling
+// by hand), so neither the reader nor WritePck can vouch for itself.
+const pckFixture = "9245d0545d5e5f6061609c5757d451d88f686b991dd99376717273"
+
+func TestPckFixture(t *testing.T) {
+	b, _ := hex.DecodeString(pckFixture)
+	sc, c, err := PckInfo(bytes.NewReader(b))
+	if err != nil || sc != PckKeyXOROff || c != 0x5a {
+		t.Fatalf("detected %v/%x (%v)", sc, c, err)
+	}
+	r, err := pck{cd{bytes.NewReader(b), 0, int64(len(b))}}.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(r); err != nil || string(got) != "22;0;\n" {
+		t.Errorf("got %q (%v)", got, err)
+	}
+	var w bytes.Buffer
+	if err := WritePck(&w, strings.NewReader("22;0;\n"), sc, c); err != nil || w.Bytes()[0] != b[0] {
+		t.Errorf("key byte %x, want %x (%v)", w.Bytes()[0], b[0], err)
+	}
+}
+
+func TestPckRoundTrip(t *testing.T) {
+	payload := []byte("22;1;\nsome;types;file;\n")
+	for _, sc := range []PckScrambling{PckXOR, PckKeyXOR, PckXOROff, PckKeyXOROff} {
+		for c := 0; c < 256; c++ {
+			b := bytes.Buffer{}
+			if err := WritePck(&b, bytes.NewReader(payload), sc, byte(c)); err != nil {
+				t.Fatal(err)
+			}
+			gsc, gc, err := PckInfo(bytes.NewReader(b.Bytes()))
+			if err != nil || gsc != sc || gc != byte(c) {
+				t.Errorf("%v/%d: detected %v/%d (%v)", sc, c, gsc, gc, err)
+				continue
+			}
+			r, err := pck{cd{bytes.NewReader(b.Bytes()), 0, int64(b.Len())}}.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(r)
+			r.Close()
+			if err != nil || !bytes.Equal(got, payload) {
+				t.Errorf("%v/%d: got %q (%v)", sc, c, got, err)
+			}
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	inst := t.TempDir()
+	writeFiles(t, inst, map[string]string{"addon/types/TBad.pck": "not a pck file"})
+	xf, err := XFiles(inst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := xf.Open("addon/types/TNope.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected not found, got %v", err)
+	}
+	_, err = xf.Open("addon/types/TBad.txt")
+	var fe *FileError
+	if !errors.Is(err, ErrScrambling) || !errors.As(err, &fe) || fe.Path != "addon/types/TBad.txt" {
+		t.Errorf("expected scrambling error, got %v", err)
+	}
+
+	// A file we can't descramble is a problem with that file, not
+	// with the installation.
+	writeFiles(t, inst, map[string]string{"addon/types/TLaser.pck": "not a pck file", "addon/types/TFoo.txt": "foo"})
+	x, err := NewX(inst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := readAll(t, &x.xf, "addon/types/TFoo.txt"); s != "foo" {
+		t.Errorf("got %q", s)
+	}
+	if len(x.GetLasers()) != 0 || !errors.Is(errors.Join(x.Problems()...), ErrScrambling) {
+		t.Errorf("bad pck: %v", x.Problems())
+	}
+
+	if err := os.WriteFile(filepath.Join(inst, "addon", "01.cat"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := XFiles(inst); !errors.Is(err, ErrMissingDat) {
+		t.Errorf("expected missing dat, got %v", err)
+	}
+}
+
+func TestWhich(t *testing.T) {
+	inst := t.TempDir()
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{"addon/types/TFoo.txt": "cat"})
+	writeFiles(t, inst, map[string]string{"addon/types/TFoo.txt": "loose"})
+	if err := WriteCatDat(filepath.Join(inst, "addon", "01"), src); err != nil {
+		t.Fatal(err)
+	}
+	xf, err := XFiles(inst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Source{
+		{Archive: "addon/01.cat", File: "addon\\types\\TFoo.txt", Size: 3},
+		{File: "addon/types/TFoo.txt", Size: 5},
+	}
+	got := xf.Which("addon/types/TFoo.txt")
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		got[i].ModTime = time.Time{}
+		if got[i] != want[i] {
+			t.Errorf("%d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+	if s := readAll(t, &xf, "addon/types/TFoo.txt"); s != "loose" {
+		t.Errorf("loose file didn't win: %q", s)
+	}
+	if b := (Source{Archive: "01.cat", File: `types\TBar.pck`}).Base(); b != "TBar.pck" {
+		t.Errorf("base of a cat path: %q", b)
+	}
+}
+
+func TestFS(t *testing.T) {
+	inst := t.TempDir()
+	src := t.TempDir()
+	writeFiles(t, src, testFiles)
+	if err := os.MkdirAll(filepath.Join(inst, "addon", "types"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteCatDat(filepath.Join(inst, "addon", "01"), src); err != nil {
+		t.Fatal(err)
+	}
+	pf, err := os.Create(filepath.Join(inst, "addon", "types", "TBar.pck"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := "22;1;\nbar;\n"
+	if err := WritePck(pf, strings.NewReader(payload), PckKeyXOROff, 17); err != nil {
+		t.Fatal(err)
+	}
+	pf.Close()
+
+	xf, err := XFiles(inst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := xfs{&xf}
+	if err := fstest.TestFS(fsys, "addon/types/TFoo.txt", "addon/types/TBar.txt", "addon/01.cat"); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := fs.Stat(fsys, "addon/types/TBar.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != int64(len(payload)) {
+		t.Errorf("pck size %d, want %d", fi.Size(), len(payload))
+	}
+	m, err := fs.Glob(fsys, "addon/*/*.txt")
+	if err != nil || len(m) != 3 {
+		t.Errorf("glob: %v %v", m, err)
+	}
+}
+
+// Listing a directory doesn't look into the pck files, only Info does.
+func TestReadDirLazy(t *testing.T) {
+	inst := t.TempDir()
+	writeFiles(t, inst, map[string]string{"types/TBad.pck": "not a pck file", "types/TGood.txt": "good"})
+	xf, err := XFiles(inst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ents, err := fs.ReadDir(xfs{&xf}, "types")
+	if err != nil || len(ents) != 2 || ents[0].Name() != "TBad.txt" || ents[0].IsDir() {
+		t.Fatalf("ReadDir: %v %v", ents, err)
+	}
+	if fi, err := ents[0].Info(); err == nil || !errors.Is(err, ErrScrambling) {
+		t.Errorf("bad pck: %v %v", fi, err)
+	}
+	if fi, err := ents[1].Info(); err != nil || fi.Size() != 4 {
+		t.Errorf("good file: %v %v", fi, err)
+	}
+}
+
+func TestBaseGame(t *testing.T) {
+	inst := t.TempDir()
+	base := t.TempDir()
+	addon := t.TempDir()
+	writeFiles(t, base, map[string]string{"types/TFoo.txt": "base foo", "types/TBar.txt": "base bar", "maps/base.xml": "<universe/>"})
+	// Without the addon/ prefix, it should get added.
+	writeFiles(t, addon, map[string]string{"types/TFoo.txt": "addon foo"})
+	if err := os.Mkdir(filepath.Join(inst, "addon"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteCatDat(filepath.Join(inst, "01"), base); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteCatDat(filepath.Join(inst, "addon", "01"), addon); err != nil {
+		t.Fatal(err)
+	}
+	xf, err := XFiles(inst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n, c := range map[string]string{
+		"addon/types/TFoo.txt": "addon foo",
+		"addon/types/TBar.txt": "base bar",
+		"types/TFoo.txt":       "base foo",
+	} {
+		if got := readAll(t, &xf, n); got != c {
+			t.Errorf("%s: got %q, want %q", n, got, c)
+		}
+	}
+	if w := xf.Which("addon/types/TFoo.txt"); len(w) != 2 || w[0].Archive != "01.cat" || w[1].Archive != "addon/01.cat" {
+		t.Errorf("bad override chain: %v", w)
+	}
+	if f := xf.files("addon/types"); len(f) != 2 {
+		t.Errorf("files: %v", f)
+	}
+	// The fs and Map see the fallback too, also for directories only
+	// the base game has.
+	fsys := xfs{&xf}
+	for d, want := range map[string][]string{
+		"addon":       {"01.cat", "01.dat", "maps", "types"},
+		"addon/types": {"TBar.txt", "TFoo.txt"},
+		"addon/maps":  {"base.xml"},
+	} {
+		ents, err := fs.ReadDir(fsys, d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, e := range ents {
+			got = append(got, e.Name())
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", d, got, want)
+		}
+	}
+	if fi, err := fs.Stat(fsys, "addon/maps/base.xml"); err != nil || fi.Size() != int64(len("<universe/>")) {
+		t.Errorf("stat: %v %v", fi, err)
+	}
+	mapped := make(map[string]bool)
+	xf.Map(func(d, f string) {
+		mapped[joinPath(d, f)] = true
+	})
+	for _, p := range []string{"addon/types/TBar.txt", "addon/types/TFoo.txt", "addon/maps/base.xml", "types/TBar.txt"} {
+		if !mapped[p] {
+			t.Errorf("Map misses %s: %v", p, mapped)
+		}
+	}
+}
+
+func TestDiffFiles(t *testing.T) {
+	a, b := t.TempDir(), t.TempDir()
+	writeFiles(t, a, map[string]string{
+		"types/same": "x", "types/changed": "a", "types/removed": "r", "types/overridden": "o",
+		"addon/types/addon": "a", "types/moved": "m",
+	})
+	writeFiles(t, b, map[string]string{
+		"addon/types/moved": "m",
+		"types/same":        "x", "types/changed": "b", "types/added": "n", "types/overridden": "o",
+		"addon/types/overridden": "p", "addon/types/addon": "a",
+	})
+	// The archives themselves don't count, only what's in them.
+	writeFiles(t, b, map[string]string{"01.cat": "", "01.dat": ""})
+	xa, err := NewX(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xb, err := NewX(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]DiffChange{
+		"addon/types/added":      DiffAdded,
+		"addon/types/changed":    DiffChanged,
+		"addon/types/removed":    DiffRemoved,
+		"addon/types/overridden": DiffChanged,
+	}
+	d := DiffFiles(xa, xb)
+	if len(d) != len(want) {
+		t.Fatalf("got %v", d)
+	}
+	for i := range d {
+		if c, ok := want[d[i].Path]; d[i].Err != nil || !ok || c != d[i].Change {
+			t.Errorf("bad diff: %v", d[i])
+		}
+	}
+	if d[3].Path != "addon/types/removed" || d[3].A.File != "types/removed" {
+		t.Errorf("removed file from the base game: %v", d[3])
+	}
+}
+
+func TestFileServer(t *testing.T) {
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{
+		"addon/types/TFoo.txt":   "0123456789",
+		"addon/objects/ship.bod": "// a body\n",
+	})
+	var pb bytes.Buffer
+	if err := WritePck(&pb, strings.NewReader("abcdefghij"), PckXOROff, 42); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, src, map[string]string{"addon/types/TBar.pck": pb.String()})
+	inst := t.TempDir()
+	if err := os.Mkdir(filepath.Join(inst, "addon"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteCatDat(filepath.Join(inst, "addon", "01"), src); err != nil {
+		t.Fatal(err)
+	}
+	x, err := NewX(inst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.FileServer(http.FS(x.FS())))
+	defer srv.Close()
+
+	get := func(p, rng string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest("GET", srv.URL+"/"+p, nil)
+		if rng != "" {
+			req.Header.Set("Range", rng)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+	for _, tc := range []struct {
+		path, rng string
+		code      int
+		body      string
+	}{
+		{"addon/types/TFoo.txt", "bytes=2-4", http.StatusPartialContent, "234"},
+		{"addon/types/TBar.txt", "bytes=7-", http.StatusPartialContent, "hij"},
+		{"addon/types/TBar.txt", "", http.StatusOK, "abcdefghij"},
+		// No extension that tells the content type, so it's sniffed.
+		{"addon/objects/ship.bod", "", http.StatusOK, "// a body\n"},
+	} {
+		if code, body := get(tc.path, tc.rng); code != tc.code || body != tc.body {
+			t.Errorf("%s %s: got %d %q, want %d %q", tc.path, tc.rng, code, body, tc.code, tc.body)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package xt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"time"
+)
+
+// The merged files of an installation as an io/fs file system. So
+// that things like http.FileServer, fs.WalkDir and fs.Glob work.
+
+type xfs struct {
+	xf *Xfiles
+}
+
+// The installation as an fs.FS. It also implements fs.ReadDirFS and
+// fs.StatFS. Files are seekable, the ones that have to be unpacked or
+// descrambled (pck files, scrambled dat files) are read into memory
+// when they are opened for that.
+func (x *X) FS() fs.FS {
+	return xfs{&x.xf}
+}
+
+// Figure out all the directories, including the ones that don't
+// have any files, just subdirectories.
+func (xf *Xfiles) mkdirs() {
+	xf.dirs = make(map[string][]string)
+	seen := make(map[string]bool)
+	for _, d := range xf.fileDirs() {
+		for d != "" && !seen[d] {
+			seen[d] = true
+			parent := path.Dir(d)
+			if parent == "." {
+				parent = ""
+			}
+			xf.dirs[parent] = append(xf.dirs[parent], path.Base(d))
+			d = parent
+		}
+	}
+}
+
+func (xf *Xfiles) isDir(d string) bool {
+	if d == "" || xf.f[d] != nil || xf.dirs[d] != nil {
+		return true
+	}
+	base, ok := baseDir(d)
+	return ok && xf.f[base] != nil
+}
+
+// fs paths have "." for the root, we have "".
+func fsName(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return "", nil
+	}
+	return name, nil
+}
+
+func (xf *Xfiles) stat(name string) (*fileInfo, error) {
+	if xf.isDir(name) {
+		return &fileInfo{name: path.Base(name), dir: true}, nil
+	}
+	srcs := xf.Which(name)
+	xd := xf.lookup(name)
+	if xd == nil || len(srcs) == 0 {
+		return nil, &FileError{Path: name, Kind: ErrNotFound}
+	}
+	src := srcs[len(srcs)-1]
+	fi := &fileInfo{name: path.Base(name), size: src.Size, mod: src.ModTime}
+	if _, ok := xd.(pck); ok {
+		sz, err := pckSize(rawXdata(xd), src.Size)
+		if err != nil {
+			return nil, fileErrorPath(err, name)
+		}
+		fi.size = sz
+	}
+	return fi, nil
+}
+
+// The size of the unpacked data. gzip keeps it (mod 2^32, but our
+// files aren't that big) in the last four bytes.
+func pckSize(xd Xdata, rawSize int64) (int64, error) {
+	r, err := xd.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	ra, ok := r.(io.ReaderAt)
+	if !ok || rawSize < 8 {
+		return 0, &FileError{Kind: ErrCorrupt}
+	}
+	sc, cookie, err := PckInfo(ra)
+	if err != nil {
+		return 0, err
+	}
+	b := make([]byte, 4)
+	if _, err := ra.ReadAt(b, rawSize-4); err != nil {
+		return 0, &FileError{Kind: ErrCorrupt, Err: err}
+	}
+	off := rawSize - 4
+	if sc == PckKeyXOR || sc == PckKeyXOROff {
+		off--
+	}
+	sz := int64(0)
+	for i := 3; i >= 0; i-- {
+		c := cookie
+		if sc == PckXOROff || sc == PckKeyXOROff {
+			c += byte(off + int64(i))
+		}
+		sz = sz<<8 | int64(b[i]^c)
+	}
+	return sz, nil
+}
+
+func (x xfs) Open(name string) (fs.File, error) {
+	n, err := fsName("open", name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := x.xf.stat(n)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if fi.dir {
+		ents, err := x.readDir(n)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return &dirFile{fi: fi, ents: ents}, nil
+	}
+	r, err := x.xf.Open(n)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	// Files straight from a dat file or the disk can seek, the
+	// unpacked or descrambled ones are read into memory so that they
+	// can too. http.FileServer needs it for ranges and sniffing.
+	if rs, ok := r.(io.ReadSeeker); ok {
+		return &file{ReadSeeker: rs, Closer: r, fi: fi}, nil
+	}
+	b, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fileErrorPath(err, n)}
+	}
+	return &file{ReadSeeker: bytes.NewReader(b), Closer: io.NopCloser(nil), fi: fi}, nil
+}
+
+func (x xfs) Stat(name string) (fs.FileInfo, error) {
+	n, err := fsName("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := x.xf.stat(n)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return fi, nil
+}
+
+func (x xfs) ReadDir(name string) ([]fs.DirEntry, error) {
+	n, err := fsName("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	ents, err := x.readDir(n)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	return ents, nil
+}
+
+func (x xfs) readDir(d string) ([]fs.DirEntry, error) {
+	if !x.xf.isDir(d) {
+		return nil, &FileError{Path: d, Kind: ErrNotFound}
+	}
+	ents := []fs.DirEntry{}
+	for _, sd := range x.xf.dirs[d] {
+		ents = append(ents, fs.FileInfoToDirEntry(&fileInfo{name: sd, dir: true}))
+	}
+	for _, f := range x.xf.files(d) {
+		ents = append(ents, &dirEntry{xf: x.xf, path: path.Join(d, f)})
+	}
+	sort.Slice(ents, func(i, j int) bool { return ents[i].Name() < ents[j].Name() })
+	return ents, nil
+}
+
+type fileInfo struct {
+	name string
+	size int64
+	mod  time.Time
+	dir  bool
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) ModTime() time.Time { return fi.mod }
+func (fi *fileInfo) IsDir() bool        { return fi.dir }
+func (fi *fileInfo) Sys() interface{}   { return nil }
+
+func (fi *fileInfo) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+// A file in a directory listing. Info is only looked up when asked
+// for, stat reads pck files to find their size.
+type dirEntry struct {
+	xf   *Xfiles
+	path string
+}
+
+func (e *dirEntry) Name() string      { return path.Base(e.path) }
+func (e *dirEntry) IsDir() bool       { return false }
+func (e *dirEntry) Type() fs.FileMode { return 0 }
+
+func (e *dirEntry) Info() (fs.FileInfo, error) {
+	fi, err := e.xf.stat(e.path)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: e.path, Err: err}
+	}
+	return fi, nil
+}
+
+type file struct {
+	io.ReadSeeker
+	io.Closer
+	fi *fileInfo
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	return f.fi, nil
+}
+
+type dirFile struct {
+	fi   *fileInfo
+	ents []fs.DirEntry
+	off  int
+}
+
+func (d *dirFile) Stat() (fs.FileInfo, error) {
+	return d.fi, nil
+}
+
+func (d *dirFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.fi.name, Err: errors.New("is a directory")}
+}
+
+func (d *dirFile) Close() error {
+	return nil
+}
+
+func (d *dirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	ents := d.ents[d.off:]
+	if n > 0 {
+		if len(ents) == 0 {
+			return nil, io.EOF
+		}
+		if len(ents) > n {
+			ents = ents[:n]
+		}
+	}
+	d.off += len(ents)
+	return ents, nil
+}
@@ -5,8 +5,11 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"os"
+	"path"
+	"path/filepath"
 	"runtime/pprof"
 	"sort"
 	"strconv"
@@ -18,13 +21,14 @@ import (
 )
 
 func usage() {
-	fmt.Fprintf(os.Stderr, "Usage: xtool <X3 directory> <ls|cat> [args]\n")
+	fmt.Fprintf(os.Stderr, "Usage: xtool <X3 directory> <ls|cat|pckinfo|pck|...> [args]\n")
 	fmt.Println(flag.NArg(), flag.NFlag())
 	os.Exit(1)
 }
 
 var cpuprofile = flag.String("cpuprofile", "", "write cpu profile `file`")
 var memprofile = flag.String("memprofile", "", "write memory profile to `file`")
+var lang = flag.Int("lang", xt.English, "language of the text, for example 49 for German")
 
 func main() {
 	flag.Parse()
@@ -44,7 +48,13 @@ func main() {
 	}
 
 	args := flag.Args()
-	x := xt.NewX(args[0])
+	// check wants to see all the bad records, not just the first one
+	// in each file.
+	x, err := xt.NewXOptions(args[0], xt.Options{Lenient: args[1] == "check"})
+	if err != nil {
+		log.Fatal(err)
+	}
+	x = x.WithLanguage(*lang)
 
 	switch args[1] {
 	case "ls":
@@ -55,18 +65,112 @@ func main() {
 		if flag.NArg() != 3 {
 			usage()
 		}
-		f := x.Open(args[2])
-		if f == nil {
-			fmt.Fprintf(os.Stderr, "No such file: %s\n", args[2])
+		f, err := x.Open(args[2])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
 			os.Exit(1)
 		}
 		defer f.Close()
-		io.Copy(os.Stdout, f)
+		if _, err := io.Copy(os.Stdout, f); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", args[2], err)
+			os.Exit(1)
+		}
+	case "pckinfo":
+		if flag.NArg() != 3 {
+			usage()
+		}
+		sc, cookie := pckInfo(x, args[2])
+		fmt.Printf("%s: %v, cookie %d\n", args[2], sc, cookie)
+	case "pck":
+		// Pack a file the same way as an existing file in the installation.
+		if flag.NArg() != 5 {
+			usage()
+		}
+		sc, cookie := pckInfo(x, args[2])
+		in, err := os.Open(args[3])
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer in.Close()
+		out, err := os.Create(args[4])
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := xt.WritePck(out, in, sc, cookie); err != nil {
+			log.Fatal(err)
+		}
+		if err := out.Close(); err != nil {
+			log.Fatal(err)
+		}
+	case "extract":
+		extract(x, args[2:])
+	case "diff":
+		if flag.NArg() != 3 {
+			usage()
+		}
+		xb, err := xt.NewX(args[2])
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, d := range xt.DiffFiles(x, xb) {
+			switch {
+			case d.Err != nil:
+				fmt.Printf("! %s: %v\n", d.Path, d.Err)
+			case d.Change == xt.DiffAdded:
+				fmt.Printf("+ %s (%v)\n", d.Path, *d.B)
+			case d.Change == xt.DiffRemoved:
+				fmt.Printf("- %s (%v)\n", d.Path, *d.A)
+			default:
+				fmt.Printf("M %s (%v -> %v)\n", d.Path, *d.A, *d.B)
+			}
+		}
+	case "typediff":
+		if flag.NArg() != 3 {
+			usage()
+		}
+		xb, err := xt.NewX(args[2])
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, d := range xt.DiffTypes(x, xb) {
+			fmt.Println(d)
+		}
+	case "languages":
+		for _, l := range x.Languages() {
+			fmt.Println(l)
+		}
+	case "check":
+		p := x.Problems()
+		for _, err := range p {
+			fmt.Println(err)
+		}
+		if len(p) != 0 {
+			os.Exit(1)
+		}
+	case "which":
+		if flag.NArg() != 3 {
+			usage()
+		}
+		srcs := x.Which(args[2])
+		if len(srcs) == 0 {
+			fmt.Fprintf(os.Stderr, "No such file: %s\n", args[2])
+			os.Exit(1)
+		}
+		for i := range srcs {
+			if i == len(srcs)-1 {
+				fmt.Printf("%v\n", srcs[i])
+			} else {
+				fmt.Printf("%v (overridden)\n", srcs[i])
+			}
+		}
 	case "catscript":
 		if flag.NArg() != 3 {
 			usage()
 		}
-		f := x.Open(args[2])
+		f, err := x.Open(args[2])
+		if err != nil {
+			log.Fatal(err)
+		}
 		defer f.Close()
 		err, scr := xt.DecodeScript(f)
 		if err != nil {
@@ -89,9 +193,38 @@ func main() {
 			ids = append(ids, k)
 		}
 		sort.Ints(ids)
-		for k := range ids {
-			fmt.Printf("%d:\t%s\n", k, text[page][k])
+		for _, id := range ids {
+			fmt.Printf("%d:\t%s\n", id, text[page][id])
+		}
+	case "galaxies":
+		for _, g := range x.Galaxies() {
+			fmt.Println(g)
+		}
+	case "universe":
+		// Decode and encode a galaxy map, to stdout.
+		g := xt.DefaultGalaxy
+		if flag.NArg() == 3 {
+			g = args[2]
+		}
+		u, err := x.GetGalaxy(g)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := u.Write(os.Stdout); err != nil {
+			log.Fatal(err)
 		}
+	case "textexport":
+		// textexport <po|csv> [t file], to stdout.
+		if flag.NArg() != 3 && flag.NArg() != 4 {
+			usage()
+		}
+		textExport(x, args[2], args[3:])
+	case "textimport":
+		// textimport <in.po|in.csv> <out.xml>
+		if flag.NArg() != 4 {
+			usage()
+		}
+		textImport(x, args[2], args[3])
 	case "grep":
 		if flag.NArg() != 3 {
 			usage()
@@ -104,9 +237,9 @@ func main() {
 			usage()
 		}
 
-		f := x.Open(args[2])
-		if f == nil {
-			fmt.Fprintf(os.Stderr, "No such file: %s\n", args[2])
+		f, err := x.Open(args[2])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
 			os.Exit(1)
 		}
 		b, err := bob.Read(f)
@@ -136,11 +269,12 @@ func main() {
 		x.Map(func(d, f string) {
 			if strings.HasSuffix(f, ".bob") {
 				n := fmt.Sprintf("%s/%s", d, f)
-				f := x.Open(n)
-				if f == nil {
-					log.Fatalf("open: %s", n)
+				f, err := x.Open(n)
+				if err != nil {
+					fmt.Printf("error: %v\n", err)
+					return
 				}
-				_, err := bob.Read(f)
+				_, err = bob.Read(f)
 				if err != nil {
 					fmt.Printf("error: %s, %v\n", n, err)
 				} else {
@@ -167,10 +301,84 @@ func main() {
 	}
 }
 
+// Write out files matching a glob as a plain tree.
+func extract(x *xt.X, args []string) {
+	fl := flag.NewFlagSet("extract", flag.ExitOnError)
+	raw := fl.Bool("raw", false, "write the files as they are stored, without unpacking")
+	fl.Parse(args)
+	if fl.NArg() != 2 {
+		usage()
+	}
+	names, err := fs.Glob(x.FS(), fl.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	outdir := fl.Arg(1)
+	for _, n := range names {
+		if fi, err := fs.Stat(x.FS(), n); err != nil || fi.IsDir() {
+			continue
+		}
+		var f io.ReadCloser
+		out := xt.PlainName(n)
+		if *raw {
+			srcs := x.Which(n)
+			f, err = x.OpenRaw(n)
+			out = path.Join(path.Dir(n), srcs[len(srcs)-1].Base())
+		} else {
+			f, err = x.Open(n)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			continue
+		}
+		err = writeFile(filepath.Join(outdir, filepath.FromSlash(out)), f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", n, err)
+			continue
+		}
+		fmt.Println(out)
+	}
+}
+
+func writeFile(fn string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(fn)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func pckInfo(x *xt.X, fn string) (xt.PckScrambling, byte) {
+	f, err := x.OpenRaw(fn)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+	ra, ok := f.(io.ReaderAt)
+	if !ok {
+		log.Fatalf("%s: can't read header", fn)
+	}
+	sc, cookie, err := xt.PckInfo(ra)
+	if err != nil {
+		log.Fatalf("%s: %v", fn, err)
+	}
+	return sc, cookie
+}
+
 func grepone(x *xt.X, fn string, needle string) {
-	f := x.Open(fn)
-	if f == nil {
-		log.Fatalf("Eh? %s", fn)
+	f, err := x.Open(fn)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return
 	}
 	defer f.Close()
 	s := bufio.NewScanner(f)
@@ -209,3 +417,73 @@ func ship(x *xt.X, a ...string) {
 		}
 	}
 }
+
+// Export the text of the installation, or of one t/ file (on disk or
+// in the installation), for translators.
+func textExport(x *xt.X, format string, file []string) {
+	var items []xt.TextItem
+	if len(file) == 0 {
+		items = x.TextItems()
+	} else {
+		var f io.ReadCloser
+		f, err := os.Open(file[0])
+		if err != nil {
+			f, err = x.Open(file[0])
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		tf, err := xt.ReadTextFile(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("%s: %v", file[0], err)
+		}
+		items = x.FileTextItems(tf)
+	}
+	var err error
+	switch format {
+	case "po":
+		err = xt.WritePO(os.Stdout, items, x.Language())
+	case "csv":
+		err = xt.WriteTextCSV(os.Stdout, items)
+	default:
+		usage()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Turn a translated PO or CSV file back into a t/ file in the
+// language given with -lang.
+func textImport(x *xt.X, in, out string) {
+	f, err := os.Open(in)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var items []xt.TextItem
+	if strings.HasSuffix(strings.ToLower(in), ".csv") {
+		items, err = xt.ReadTextCSV(f)
+	} else {
+		items, err = xt.ReadPO(f)
+	}
+	f.Close()
+	if err != nil {
+		log.Fatalf("%s: %v", in, err)
+	}
+	titles := make(map[int]string)
+	for _, p := range x.TextPages() {
+		titles[p.ID] = p.Title
+	}
+	tf := xt.NewTextFile(items, x.Language(), titles)
+	o, err := os.Create(out)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := tf.Write(o); err != nil {
+		log.Fatal(err)
+	}
+	if err := o.Close(); err != nil {
+		log.Fatal(err)
+	}
+}